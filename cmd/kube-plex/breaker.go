@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/klog/v2"
+)
+
+// Circuit breaker defaults, the breaker opens after 5 failed job creations
+// within a minute and stays open for 30 seconds
+const (
+	defaultBreakerThreshold = 5
+	defaultBreakerWindow    = time.Minute
+	defaultBreakerCooldown  = 30 * time.Second
+	defaultBreakerState     = "kube-plex-breaker.json"
+)
+
+// circuitBreaker stops creating transcode jobs for a cooldown period after
+// repeated API failures, so that sessions don't pile retries onto a struggling
+// API server. Each transcode is a separate kube-plex process, the state is
+// shared through a file in the PMS container. Updates from concurrent
+// processes may be lost, which only delays opening or closing the breaker.
+type circuitBreaker struct {
+	path      string        // state file
+	threshold int           // consecutive failures opening the breaker
+	window    time.Duration // failures older than this are forgotten
+	cooldown  time.Duration // time the breaker stays open
+	local     bool          // transcode locally while the breaker is open
+}
+
+// breakerState is the shared state of the circuit breaker. The breaker is
+// closed while OpenUntil is zero, open until OpenUntil and half-open after
+// that, letting a single job creation through to test the API server.
+type breakerState struct {
+	Failures  int       `json:"failures"`
+	Since     time.Time `json:"since"`
+	OpenUntil time.Time `json:"openUntil"`
+}
+
+// parseCircuitBreaker builds the circuit breaker from its environment
+// settings, empty settings use the defaults. A threshold of 0 disables the
+// breaker and returns nil.
+func parseCircuitBreaker(threshold, window, cooldown, fallback, path string) (*circuitBreaker, error) {
+	b := &circuitBreaker{
+		path:      path,
+		threshold: defaultBreakerThreshold,
+		window:    defaultBreakerWindow,
+		cooldown:  defaultBreakerCooldown,
+	}
+	if threshold != "" {
+		n, err := strconv.Atoi(threshold)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid circuit breaker threshold `%s`", threshold)
+		}
+		if n == 0 {
+			return nil, nil
+		}
+		b.threshold = n
+	}
+	for _, d := range []struct {
+		name string
+		v    string
+		dst  *time.Duration
+	}{{"window", window, &b.window}, {"cooldown", cooldown, &b.cooldown}} {
+		if d.v == "" {
+			continue
+		}
+		v, err := time.ParseDuration(d.v)
+		if err != nil || v <= 0 {
+			return nil, fmt.Errorf("invalid circuit breaker %s `%s`", d.name, d.v)
+		}
+		*d.dst = v
+	}
+	switch fallback {
+	case "", maintenanceFail:
+	case maintenanceLocal:
+		b.local = true
+	default:
+		return nil, fmt.Errorf("invalid circuit breaker fallback `%s`, expecting %s or %s", fallback, maintenanceFail, maintenanceLocal)
+	}
+	if b.path == "" {
+		b.path = filepath.Join(os.TempDir(), defaultBreakerState)
+	}
+	return b, nil
+}
+
+// allow returns ErrCircuitOpen while the breaker is open. Once the cooldown
+// has passed a single caller is let through, others are rejected for another
+// cooldown period unless the trial succeeds.
+func (b *circuitBreaker) allow() error {
+	if b == nil {
+		return nil
+	}
+	s := b.load()
+	now := clk.Now()
+	if s.OpenUntil.IsZero() {
+		return nil
+	}
+	if now.Before(s.OpenUntil) {
+		return fmt.Errorf("%w after %d failed job creations, retrying after %s", ErrCircuitOpen, s.Failures, s.OpenUntil.Format(time.RFC3339))
+	}
+	s.OpenUntil = now.Add(b.cooldown)
+	b.save(s)
+	return nil
+}
+
+// record updates the breaker with the result of a job creation. Only server
+// side and transport failures are counted, errors caused by the request like
+// an invalid job, a denied admission or missing permissions are left to the
+// session they happen in.
+func (b *circuitBreaker) record(err error) {
+	if b == nil || (err != nil && !isServerFailure(err)) {
+		return
+	}
+	s := b.load()
+	if err == nil {
+		if s != (breakerState{}) {
+			b.save(breakerState{})
+		}
+		return
+	}
+
+	now := clk.Now()
+	switch {
+	case !s.OpenUntil.IsZero():
+		// failed trial while half-open
+		s.OpenUntil = now.Add(b.cooldown)
+	case s.Failures == 0 || now.Sub(s.Since) > b.window:
+		s = breakerState{Failures: 1, Since: now}
+	default:
+		s.Failures++
+	}
+	if s.OpenUntil.IsZero() && s.Failures >= b.threshold {
+		klog.Errorf("Creating transcode jobs failed %d times, pausing job creation for %s", s.Failures, b.cooldown)
+		s.OpenUntil = now.Add(b.cooldown)
+	}
+	b.save(s)
+}
+
+// isServerFailure reports whether err indicates an API server that is down,
+// overloaded or unreachable
+func isServerFailure(err error) bool {
+	var ne net.Error
+	switch {
+	case apierrors.IsServerTimeout(err), apierrors.IsTimeout(err), apierrors.IsTooManyRequests(err),
+		apierrors.IsInternalError(err), apierrors.IsServiceUnavailable(err), apierrors.IsUnexpectedServerError(err):
+		return true
+	case errors.As(err, &ne), errors.Is(err, context.DeadlineExceeded):
+		return true
+	}
+	return false
+}
+
+// load reads the breaker state, a missing or unreadable state file is a
+// closed breaker
+func (b *circuitBreaker) load() breakerState {
+	var s breakerState
+	d, err := os.ReadFile(b.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s
+	}
+	if err == nil {
+		err = json.Unmarshal(d, &s)
+	}
+	if err != nil {
+		klog.Errorf("Unable to read circuit breaker state: %v", err)
+		return breakerState{}
+	}
+	return s
+}
+
+// save replaces the breaker state file, errors are logged as the breaker only
+// protects the API server
+func (b *circuitBreaker) save(s breakerState) {
+	d, err := json.Marshal(s)
+	if err != nil {
+		klog.Errorf("Unable to encode circuit breaker state: %v", err)
+		return
+	}
+	f, err := os.CreateTemp(filepath.Dir(b.path), filepath.Base(b.path)+".*")
+	if err != nil {
+		klog.Errorf("Unable to write circuit breaker state: %v", err)
+		return
+	}
+	_, err = f.Write(d)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), b.path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		klog.Errorf("Unable to write circuit breaker state: %v", err)
+	}
+}
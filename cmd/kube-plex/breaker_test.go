@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	batch "k8s.io/api/batch/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	testingclock "k8s.io/utils/clock/testing"
+)
+
+func Test_parseCircuitBreaker(t *testing.T) {
+	tests := []struct {
+		name                                        string
+		threshold, window, cooldown, fallback, path string
+		want                                        *circuitBreaker
+		wantErr                                     bool
+	}{
+		{"defaults", "", "", "", "", "/tmp/state", &circuitBreaker{path: "/tmp/state", threshold: 5, window: time.Minute, cooldown: 30 * time.Second}, false},
+		{"configured", "3", "10s", "2m", "local", "/tmp/state", &circuitBreaker{path: "/tmp/state", threshold: 3, window: 10 * time.Second, cooldown: 2 * time.Minute, local: true}, false},
+		{"default state file", "", "", "", "fail", "", &circuitBreaker{path: filepath.Join(os.TempDir(), "kube-plex-breaker.json"), threshold: 5, window: time.Minute, cooldown: 30 * time.Second}, false},
+		{"disabled", "0", "", "", "", "", nil, false},
+		{"invalid threshold", "-1", "", "", "", "", nil, true},
+		{"invalid window", "", "soon", "", "", "", nil, true},
+		{"zero cooldown", "", "", "0s", "", "", nil, true},
+		{"invalid fallback", "", "", "", "retry", "", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseCircuitBreaker(tt.threshold, tt.window, tt.cooldown, tt.fallback, tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseCircuitBreaker() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseCircuitBreaker() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_circuitBreaker(t *testing.T) {
+	apiErr := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	var fc *testingclock.FakeClock
+	newBreaker := func(t *testing.T) *circuitBreaker {
+		fc = fakeClock(t)
+		return &circuitBreaker{path: filepath.Join(t.TempDir(), "state.json"), threshold: 3, window: time.Minute, cooldown: 30 * time.Second}
+	}
+	fail := func(b *circuitBreaker, n int) {
+		for i := 0; i < n; i++ {
+			b.record(apiErr)
+		}
+	}
+
+	t.Run("opens after threshold", func(t *testing.T) {
+		b := newBreaker(t)
+		fail(b, 2)
+		if err := b.allow(); err != nil {
+			t.Fatalf("allow() below threshold = %v, want nil", err)
+		}
+		fail(b, 1)
+		if err := b.allow(); !errors.Is(err, ErrCircuitOpen) {
+			t.Errorf("allow() at threshold = %v, want %v", err, ErrCircuitOpen)
+		}
+	})
+
+	t.Run("success resets failures", func(t *testing.T) {
+		b := newBreaker(t)
+		fail(b, 2)
+		b.record(nil)
+		fail(b, 2)
+		if err := b.allow(); err != nil {
+			t.Errorf("allow() = %v, want nil", err)
+		}
+	})
+
+	t.Run("old failures are forgotten", func(t *testing.T) {
+		b := newBreaker(t)
+		fail(b, 2)
+		fc.Step(2 * time.Minute)
+		fail(b, 1)
+		if err := b.allow(); err != nil {
+			t.Errorf("allow() = %v, want nil", err)
+		}
+	})
+
+	t.Run("counts server failures", func(t *testing.T) {
+		for _, err := range []error{
+			apierrors.NewServerTimeout(batch.Resource("jobs"), "create", 1),
+			apierrors.NewTimeoutError("request timed out", 1),
+			apierrors.NewTooManyRequests("too many requests", 1),
+			apierrors.NewInternalError(errors.New("etcd unavailable")),
+			apierrors.NewServiceUnavailable("unavailable"),
+			fmt.Errorf("creating job: %w", context.DeadlineExceeded),
+			apiErr,
+		} {
+			b := newBreaker(t)
+			b.record(err)
+			b.record(err)
+			b.record(err)
+			if got := b.allow(); !errors.Is(got, ErrCircuitOpen) {
+				t.Errorf("allow() after %v = %v, want %v", err, got, ErrCircuitOpen)
+			}
+		}
+	})
+
+	t.Run("ignores request errors", func(t *testing.T) {
+		webhookDenied := &apierrors.StatusError{ErrStatus: metav1.Status{
+			Status: metav1.StatusFailure, Code: http.StatusForbidden, Reason: metav1.StatusReasonForbidden,
+			Message: `admission webhook "policy.example.com" denied the request: privileged containers are not allowed`,
+		}}
+		tests := []struct {
+			name string
+			err  error
+		}{
+			{"invalid", apierrors.NewInvalid(schema.GroupKind{Group: "batch", Kind: "Job"}, "pms-transcode", nil)},
+			{"already exists", apierrors.NewAlreadyExists(batch.Resource("jobs"), "pms-transcode")},
+			{"webhook denied", webhookDenied},
+			{"jobs forbidden", ErrJobsForbidden},
+			{"jobs unavailable", ErrJobsUnavailable},
+			{"namespace terminating", ErrNamespaceTerminating},
+			{"cancelled", context.Canceled},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				b := newBreaker(t)
+				fail(b, 2)
+				want := b.load()
+				b.record(tt.err)
+				b.record(tt.err)
+				if got := b.load(); got != want {
+					t.Errorf("state after %v = %+v, want %+v", tt.err, got, want)
+				}
+				if err := b.allow(); err != nil {
+					t.Errorf("allow() = %v, want nil", err)
+				}
+			})
+		}
+	})
+
+	t.Run("half-open trial", func(t *testing.T) {
+		b := newBreaker(t)
+		fail(b, 3)
+
+		// a single trial after the cooldown, other sessions keep failing fast
+		fc.Step(31 * time.Second)
+		if err := b.allow(); err != nil {
+			t.Fatalf("allow() after cooldown = %v, want nil", err)
+		}
+		if err := b.allow(); !errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("allow() during trial = %v, want %v", err, ErrCircuitOpen)
+		}
+
+		// failed trial reopens the breaker for another cooldown
+		b.record(apiErr)
+		fc.Step(20 * time.Second)
+		if err := b.allow(); !errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("allow() after failed trial = %v, want %v", err, ErrCircuitOpen)
+		}
+
+		// successful trial closes the breaker
+		fc.Step(11 * time.Second)
+		if err := b.allow(); err != nil {
+			t.Fatalf("allow() after second cooldown = %v, want nil", err)
+		}
+		b.record(nil)
+		if err := b.allow(); err != nil {
+			t.Errorf("allow() after successful trial = %v, want nil", err)
+		}
+		fail(b, 2)
+		if err := b.allow(); err != nil {
+			t.Errorf("allow() after closing = %v, want nil", err)
+		}
+	})
+
+	t.Run("corrupt state is closed", func(t *testing.T) {
+		b := newBreaker(t)
+		if err := os.WriteFile(b.path, []byte("{"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.allow(); err != nil {
+			t.Errorf("allow() = %v, want nil", err)
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		var b *circuitBreaker
+		b.record(apiErr)
+		if err := b.allow(); err != nil {
+			t.Errorf("allow() = %v, want nil", err)
+		}
+	})
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+// claimBindInterval is the interval of checking the PVCs of the transcode pod
+// while waiting for them to be bound
+const claimBindInterval = 2 * time.Second
+
+// podClaims returns the names of the PVCs referenced by the volumes of spec.
+// Claims of generic ephemeral volumes are created with the pod and aren't
+// included.
+func podClaims(spec corev1.PodSpec) []string {
+	var claims []string
+	for _, v := range spec.Volumes {
+		if v.PersistentVolumeClaim != nil {
+			claims = append(claims, v.PersistentVolumeClaim.ClaimName)
+		}
+	}
+	return claims
+}
+
+// waitForClaimsBound waits until the named PVCs in namespace are bound.
+// ErrClaimUnbound is returned if a claim isn't bound within the timeout, and
+// ErrVolumeMissing if a claim doesn't exist.
+func waitForClaimsBound(ctx context.Context, cl kubernetes.Interface, namespace string, claims []string, timeout time.Duration) error {
+	deadline := clk.NewTimer(timeout)
+	defer deadline.Stop()
+	t := clk.NewTimer(claimBindInterval)
+	defer t.Stop()
+	for {
+		pending, phase, err := unboundClaim(ctx, cl, namespace, claims)
+		if err != nil || pending == "" {
+			return err
+		}
+		klog.V(1).Infof("Waiting for PVC %s to be bound, it is %s", pending, phase)
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("context cancelled: %v", ctx.Err())
+		case <-deadline.C():
+			return fmt.Errorf("%w: PVC %s is still %s after %v", ErrClaimUnbound, pending, phase, timeout)
+		case <-t.C():
+			t.Reset(claimBindInterval)
+		}
+	}
+}
+
+// unboundClaim returns the first of claims that isn't bound and its phase,
+// empty if all claims are bound
+func unboundClaim(ctx context.Context, cl kubernetes.Interface, namespace string, claims []string) (string, corev1.PersistentVolumeClaimPhase, error) {
+	for _, c := range claims {
+		pvc, err := cl.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, c, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return "", "", fmt.Errorf("%w: PVC %s doesn't exist in namespace %s", ErrVolumeMissing, c, namespace)
+		}
+		if err != nil {
+			return "", "", fmt.Errorf("unable to get PVC %s: %w", c, err)
+		}
+		if pvc.Status.Phase != corev1.ClaimBound {
+			return c, pvc.Status.Phase, nil
+		}
+	}
+	return "", "", nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func Test_podClaims(t *testing.T) {
+	spec := corev1.PodSpec{Volumes: []corev1.Volume{
+		{Name: "shared", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+		{Name: "media", VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "media"}}},
+		{Name: "scratch", VolumeSource: corev1.VolumeSource{Ephemeral: &corev1.EphemeralVolumeSource{}}},
+		{Name: "config", VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "plex-config"}}},
+	}}
+	if got, want := podClaims(spec), []string{"media", "plex-config"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("podClaims() = %v, want %v", got, want)
+	}
+}
+
+func Test_waitForClaimsBound(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	claim := func(name string, phase corev1.PersistentVolumeClaimPhase) *corev1.PersistentVolumeClaim {
+		return &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "plex"}, Status: corev1.PersistentVolumeClaimStatus{Phase: phase}}
+	}
+
+	t.Run("bound", func(t *testing.T) {
+		cl := fake.NewSimpleClientset(claim("media", corev1.ClaimBound), claim("config", corev1.ClaimBound))
+		if err := waitForClaimsBound(ctx, cl, "plex", []string{"media", "config"}, time.Minute); err != nil {
+			t.Errorf("waitForClaimsBound() error = %v, want nil", err)
+		}
+	})
+
+	t.Run("pending claim gets bound", func(t *testing.T) {
+		cl := fake.NewSimpleClientset(claim("media", corev1.ClaimPending))
+		gets := 0
+		cl.PrependReactor("get", "persistentvolumeclaims", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			// pending on the first check, bound by the second
+			if gets++; gets == 2 {
+				if err := cl.Tracker().Update(corev1.SchemeGroupVersion.WithResource("persistentvolumeclaims"), claim("media", corev1.ClaimBound), "plex"); err != nil {
+					t.Errorf("updating claim: %v", err)
+				}
+			}
+			return false, nil, nil
+		})
+		fc := fakeClock(t)
+
+		errCh := make(chan error, 1)
+		go func() { errCh <- waitForClaimsBound(ctx, cl, "plex", []string{"media"}, time.Minute) }()
+		if err := stepUntilDone(t, fc, claimBindInterval, errCh); err != nil {
+			t.Errorf("waitForClaimsBound() error = %v, want nil", err)
+		}
+		if gets != 2 {
+			t.Errorf("waitForClaimsBound() checked the claim %d times, want 2", gets)
+		}
+	})
+
+	t.Run("never bound", func(t *testing.T) {
+		cl := fake.NewSimpleClientset(claim("media", corev1.ClaimPending))
+		fc := fakeClock(t)
+
+		errCh := make(chan error, 1)
+		go func() { errCh <- waitForClaimsBound(ctx, cl, "plex", []string{"media"}, time.Minute) }()
+		if err := stepUntilDone(t, fc, claimBindInterval, errCh); !errors.Is(err, ErrClaimUnbound) {
+			t.Errorf("waitForClaimsBound() error = %v, want %v", err, ErrClaimUnbound)
+		}
+	})
+
+	t.Run("missing claim", func(t *testing.T) {
+		cl := fake.NewSimpleClientset()
+		if err := waitForClaimsBound(ctx, cl, "plex", []string{"media"}, time.Minute); !errors.Is(err, ErrVolumeMissing) {
+			t.Errorf("waitForClaimsBound() error = %v, want %v", err, ErrVolumeMissing)
+		}
+	})
+}
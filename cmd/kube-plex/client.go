@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// restConfig returns in-cluster configuration, falling back to kubeconfig for
+// local development
+func restConfig() (*rest.Config, error) {
+	cfg, err := rest.InClusterConfig()
+	if err == nil {
+		return cfg, nil
+	}
+
+	kubeconfig := filepath.Join("~", ".kube", "config")
+	if ke := os.Getenv("KUBECONFIG"); len(ke) > 0 {
+		kubeconfig = ke
+	}
+	return clientcmd.BuildConfigFromFlags("", kubeconfig)
+}
+
+// serviceAccountNamespaceFile is the namespace of the service account token
+// mounted into pods, the namespace of the pod
+const serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// podIdentity returns the name and namespace of the PMS pod kube-plex runs in.
+// POD_NAME and POD_NAMESPACE set with the downward API take precedence. Without
+// them the name is read from HOSTNAME, which is the pod name unless the pod
+// sets a hostname or uses the host network, and the namespace from nsFile.
+func podIdentity(getenv func(string) string, nsFile string) (string, string, error) {
+	name := getenv("POD_NAME")
+	if name == "" {
+		name = getenv("HOSTNAME")
+	}
+	namespace := getenv("POD_NAMESPACE")
+	if namespace == "" {
+		b, err := os.ReadFile(nsFile)
+		if err != nil && !os.IsNotExist(err) {
+			return "", "", fmt.Errorf("unable to read pod namespace: %w", err)
+		}
+		namespace = strings.TrimSpace(string(b))
+	}
+
+	switch {
+	case name == "":
+		return "", "", fmt.Errorf("%w: pod name unknown, set POD_NAME with the downward API", ErrPodNotFound)
+	case namespace == "":
+		return "", "", fmt.Errorf("%w: pod namespace unknown, set POD_NAMESPACE with the downward API or mount a service account token", ErrPodNotFound)
+	}
+	return name, namespace, nil
+}
+
+// parseImpersonation builds impersonation configuration from a user name and a
+// comma separated list of groups. Empty user and groups disable impersonation.
+//
+// Impersonation requires the kube-plex service account to have the
+// `impersonate` verb on `users` and `groups` (and `serviceaccounts` when
+// impersonating a service account) in a ClusterRole. The impersonated
+// identity needs the permissions to create, get, watch and delete jobs in the
+// PMS namespace.
+func parseImpersonation(user, groups string) (rest.ImpersonationConfig, error) {
+	user = strings.TrimSpace(user)
+	var g []string
+	if strings.TrimSpace(groups) != "" {
+		for _, n := range strings.Split(groups, ",") {
+			n = strings.TrimSpace(n)
+			if n == "" {
+				return rest.ImpersonationConfig{}, fmt.Errorf("empty group name in `%s`", groups)
+			}
+			g = append(g, n)
+		}
+	}
+
+	if user == "" && len(g) > 0 {
+		return rest.ImpersonationConfig{}, fmt.Errorf("impersonating groups requires a user name")
+	}
+
+	return rest.ImpersonationConfig{UserName: user, Groups: g}, nil
+}
+
+// impersonatedConfig returns a copy of cfg which impersonates the given identity
+func impersonatedConfig(cfg *rest.Config, imp rest.ImpersonationConfig) *rest.Config {
+	c := rest.CopyConfig(cfg)
+	c.Impersonate = imp
+	return c
+}
+
+// protobufConfig returns a copy of cfg which uses the protobuf wire format. All
+// types kube-plex uses are built-in types with protobuf support, JSON is still
+// accepted in responses.
+func protobufConfig(cfg *rest.Config) *rest.Config {
+	c := rest.CopyConfig(cfg)
+	c.ContentType = runtime.ContentTypeProtobuf
+	c.AcceptContentTypes = runtime.ContentTypeProtobuf + "," + runtime.ContentTypeJSON
+	return c
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	batch "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+)
+
+func Test_podIdentity(t *testing.T) {
+	dir := t.TempDir()
+	nsFile := filepath.Join(dir, "namespace")
+	if err := os.WriteFile(nsFile, []byte("media\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(dir, "missing")
+
+	tests := []struct {
+		name          string
+		env           map[string]string
+		nsFile        string
+		wantName      string
+		wantNamespace string
+		wantErr       error
+	}{
+		{"downward API", map[string]string{"POD_NAME": "pms-0", "POD_NAMESPACE": "plex", "HOSTNAME": "other"}, nsFile, "pms-0", "plex", nil},
+		{"hostname", map[string]string{"HOSTNAME": "pms-1", "POD_NAMESPACE": "plex"}, missing, "pms-1", "plex", nil},
+		{"service account namespace", map[string]string{"POD_NAME": "pms-0"}, nsFile, "pms-0", "media", nil},
+		{"hostname and service account namespace", map[string]string{"HOSTNAME": "pms-1"}, nsFile, "pms-1", "media", nil},
+		{"no name", map[string]string{"POD_NAMESPACE": "plex"}, nsFile, "", "", ErrPodNotFound},
+		{"no namespace", map[string]string{"POD_NAME": "pms-0"}, missing, "", "", ErrPodNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, namespace, err := podIdentity(func(k string) string { return tt.env[k] }, tt.nsFile)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("podIdentity() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if name != tt.wantName || namespace != tt.wantNamespace {
+				t.Errorf("podIdentity() = %s/%s, want %s/%s", namespace, name, tt.wantNamespace, tt.wantName)
+			}
+		})
+	}
+}
+
+func Test_parseImpersonation(t *testing.T) {
+	tests := []struct {
+		name    string
+		user    string
+		groups  string
+		want    rest.ImpersonationConfig
+		wantErr bool
+	}{
+		{"no impersonation", "", "", rest.ImpersonationConfig{}, false},
+		{"user only", "plex", "", rest.ImpersonationConfig{UserName: "plex"}, false},
+		{"user and groups", "plex", "media, transcoders", rest.ImpersonationConfig{UserName: "plex", Groups: []string{"media", "transcoders"}}, false},
+		{"groups without user", "", "media", rest.ImpersonationConfig{}, true},
+		{"empty group", "plex", "media,,transcoders", rest.ImpersonationConfig{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseImpersonation(tt.user, tt.groups)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseImpersonation() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseImpersonation() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_impersonatedConfig(t *testing.T) {
+	cfg := &rest.Config{Host: "https://kubernetes"}
+	imp := rest.ImpersonationConfig{UserName: "plex"}
+	got := impersonatedConfig(cfg, imp)
+	if !reflect.DeepEqual(got.Impersonate, imp) {
+		t.Errorf("impersonatedConfig() impersonation = %v, want %v", got.Impersonate, imp)
+	}
+	if cfg.Impersonate.UserName != "" {
+		t.Errorf("impersonatedConfig() modified the original config")
+	}
+}
+
+func Test_protobufConfig(t *testing.T) {
+	cfg := &rest.Config{Host: "https://kubernetes"}
+	got := protobufConfig(cfg)
+	if got.ContentType != "application/vnd.kubernetes.protobuf" || got.AcceptContentTypes != "application/vnd.kubernetes.protobuf,application/json" {
+		t.Errorf("protobufConfig() content types = %s, %s", got.ContentType, got.AcceptContentTypes)
+	}
+	if cfg.ContentType != "" {
+		t.Errorf("protobufConfig() modified the original config")
+	}
+
+	// all types kube-plex reads and writes need to round trip through protobuf
+	info, ok := runtime.SerializerInfoForMediaType(scheme.Codecs.SupportedMediaTypes(), runtime.ContentTypeProtobuf)
+	if !ok {
+		t.Fatalf("no protobuf serializer")
+	}
+	versions := schema.GroupVersions{corev1.SchemeGroupVersion, batch.SchemeGroupVersion}
+	for _, obj := range []runtime.Object{&corev1.Pod{}, &corev1.Service{}, &corev1.ConfigMap{}, &corev1.ResourceQuotaList{}, &corev1.PodList{}, &batch.Job{}} {
+		codec := scheme.Codecs.CodecForVersions(info.Serializer, scheme.Codecs.UniversalDeserializer(), versions, versions)
+		data, err := runtime.Encode(codec, obj)
+		if err != nil {
+			t.Errorf("encoding %T: %v", obj, err)
+			continue
+		}
+		if _, err := runtime.Decode(codec, data); err != nil {
+			t.Errorf("decoding %T: %v", obj, err)
+		}
+	}
+}
+
+// benchmarkJob is a transcode job of typical size for the encoding benchmarks
+func benchmarkJob() *batch.Job {
+	m := PmsMetadata{Name: "pms", Namespace: "plex", UID: "abc123", PmsImage: "pms:latest", PmsAddr: "kubeplex:32400", KubePlexImage: "kubeplex:latest",
+		VolumeMounts: []corev1.VolumeMount{{Name: "data", MountPath: "/data"}, {Name: "transcode", MountPath: "/transcode"}},
+		Volumes:      []corev1.Volume{{Name: "data"}, {Name: "transcode"}}}
+	env := []string{"PATH=/usr/bin", "PLEX_MEDIA_SERVER_HOME=/usr/lib/plexmediaserver", "X_PLEX_TOKEN=token"}
+	args := []string{"/usr/lib/plexmediaserver/Plex Transcoder", "-codec:0", "h264", "-i", "/data/movie.mkv", "-codec:0", "libx264", "-f", "dash", "/transcode/dash"}
+	job, _ := generateJob("/transcode", m, env, args)
+	job.APIVersion, job.Kind = "batch/v1", "Job"
+	job.CreationTimestamp = metav1.Now()
+	return job
+}
+
+func benchmarkEncoding(b *testing.B, mediaType string) {
+	info, _ := runtime.SerializerInfoForMediaType(scheme.Codecs.SupportedMediaTypes(), mediaType)
+	codec := scheme.Codecs.CodecForVersions(info.Serializer, scheme.Codecs.UniversalDeserializer(), batch.SchemeGroupVersion, batch.SchemeGroupVersion)
+	job := benchmarkJob()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		data, err := runtime.Encode(codec, job)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := runtime.Decode(codec, data); err != nil {
+			b.Fatal(err)
+		}
+		b.SetBytes(int64(len(data)))
+	}
+}
+
+func BenchmarkJobEncodingJSON(b *testing.B)     { benchmarkEncoding(b, runtime.ContentTypeJSON) }
+func BenchmarkJobEncodingProtobuf(b *testing.B) { benchmarkEncoding(b, runtime.ContentTypeProtobuf) }
@@ -0,0 +1,7 @@
+package main
+
+import "k8s.io/utils/clock"
+
+// clk is the clock of kube-plex timeouts, intervals and timestamps. Tests
+// replace it with a fake clock to step through time deterministically.
+var clk clock.Clock = clock.RealClock{}
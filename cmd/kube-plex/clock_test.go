@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	testingclock "k8s.io/utils/clock/testing"
+)
+
+// fakeClock replaces clk with a fake clock for the duration of the test
+func fakeClock(t *testing.T) *testingclock.FakeClock {
+	fc := testingclock.NewFakeClock(time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC))
+	old := clk
+	clk = fc
+	t.Cleanup(func() { clk = old })
+	return fc
+}
+
+// waitForTimer waits until the code under test has started a timer on fc, so
+// that stepping the clock fires it
+func waitForTimer(t *testing.T, fc *testingclock.FakeClock) {
+	t.Helper()
+	if err := wait.PollImmediate(time.Millisecond, 5*time.Second, func() (bool, error) { return fc.HasWaiters(), nil }); err != nil {
+		t.Fatalf("timer not started: %v", err)
+	}
+}
+
+// stepUntilDone steps fc by d until the code under test returns on errCh,
+// timers it resets between steps fire on a later step
+func stepUntilDone(t *testing.T, fc *testingclock.FakeClock, d time.Duration, errCh <-chan error) error {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case err := <-errCh:
+			return err
+		case <-timeout:
+			t.Fatalf("code under test did not return")
+		case <-time.After(time.Millisecond):
+			fc.Step(d)
+		}
+	}
+}
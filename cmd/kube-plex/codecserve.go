@@ -67,9 +67,16 @@ func (c codecServe) codecPackage(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// healthz reports the codec server as ready, for launchers waiting for the
+// codec server before downloading codecs
+func healthz(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintln(w, "ok")
+}
+
 func startCodecServe(path string, l net.Listener) error {
 	fp := os.DirFS(path)
 	f := codecServe{fs: fp}
 	http.HandleFunc("/", f.codecPackage)
+	http.HandleFunc("/healthz", healthz)
 	return http.Serve(l, nil)
 }
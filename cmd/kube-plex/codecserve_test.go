@@ -60,3 +60,17 @@ func Test_codecPackage(t *testing.T) {
 		})
 	}
 }
+
+func Test_healthz(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(healthz))
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("healthz() HTTP GET err = %v", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("healthz() returned HTTP error: %v", res.Status)
+	}
+}
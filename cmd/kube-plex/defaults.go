@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+)
+
+// podDefaultsKey is the key of the defaults ConfigMap holding the pod spec
+// fragment
+const podDefaultsKey = "podSpec"
+
+// loadPodDefaults returns the pod spec defaults from a ConfigMap, referenced as
+// `name` or `namespace/name`. A missing ConfigMap or key means no defaults.
+func loadPodDefaults(ctx context.Context, cl kubernetes.Interface, namespace, ref string) (json.RawMessage, error) {
+	namespace, name, err := configMapRef(namespace, ref)
+	if err != nil {
+		return nil, err
+	}
+	cm, err := cl.CoreV1().ConfigMaps(namespace).Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch ConfigMap %s/%s: %w", namespace, name, err)
+	}
+	d, ok := cm.Data[podDefaultsKey]
+	if !ok {
+		return nil, nil
+	}
+	pd, err := parsePodDefaults(d)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s in ConfigMap %s/%s: %w", podDefaultsKey, namespace, name, err)
+	}
+	return pd, nil
+}
+
+// parsePodDefaults converts a YAML or JSON pod spec fragment to JSON. Unknown
+// fields are rejected, as a typo would otherwise silently drop a default.
+func parsePodDefaults(s string) (json.RawMessage, error) {
+	j, err := yaml.YAMLToJSON([]byte(s))
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse pod spec: %v", err)
+	}
+	if bytes.Equal(bytes.TrimSpace(j), []byte("null")) {
+		return nil, nil
+	}
+	var spec corev1.PodSpec
+	dec := json.NewDecoder(bytes.NewReader(j))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&spec); err != nil {
+		return nil, fmt.Errorf("unable to parse pod spec: %v", err)
+	}
+	return json.RawMessage(j), nil
+}
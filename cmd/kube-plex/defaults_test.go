@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func Test_loadPodDefaults(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cl := fake.NewSimpleClientset(
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "ops", Name: "defaults"}, Data: map[string]string{"podSpec": "priorityClassName: transcode\n"}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "plex", Name: "json"}, Data: map[string]string{"podSpec": `{"priorityClassName": "transcode"}`}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "plex", Name: "unset"}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "plex", Name: "typo"}, Data: map[string]string{"podSpec": "priorityClass: transcode\n"}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "plex", Name: "invalid"}, Data: map[string]string{"podSpec": "tolerations: {key: a\n"}},
+	)
+
+	tests := []struct {
+		name    string
+		ref     string
+		want    string
+		wantErr bool
+	}{
+		{"yaml in other namespace", "ops/defaults", `{"priorityClassName":"transcode"}`, false},
+		{"json", "json", `{"priorityClassName":"transcode"}`, false},
+		{"key missing", "unset", "", false},
+		{"configmap missing", "missing", "", false},
+		{"unknown field", "typo", "", true},
+		{"invalid yaml", "invalid", "", true},
+		{"invalid reference", "/defaults", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := loadPodDefaults(ctx, cl, "plex", tt.ref)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("loadPodDefaults() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if string(got) != tt.want {
+				t.Errorf("loadPodDefaults() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_generateJob_podDefaults(t *testing.T) {
+	defaults, err := parsePodDefaults(`
+priorityClassName: fleet
+tolerations:
+- key: transcode
+  operator: Exists
+nodeSelector:
+  pool: transcode
+  kubernetes.io/arch: arm64
+restartPolicy: Always
+containers:
+- name: plex
+  image: other
+  resources:
+    limits:
+      memory: 4Gi
+  env:
+  - name: TZ
+    value: UTC
+- name: exporter
+  image: exporter
+`)
+	if err != nil {
+		t.Fatalf("parsePodDefaults() error = %v", err)
+	}
+	m := PmsMetadata{Name: "pms", Namespace: "plex", UID: "abc123", PmsImage: "pms:latest", PmsAddr: "kubeplex:32400", KubePlexImage: "kubeplex:latest",
+		PodDefaults: defaults,
+		// annotations override the defaults
+		ResourceLimits: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("2")},
+		Arch:           func(s string) *string { return &s }("amd64"),
+		// the pod template overrides both
+		PodTemplate: []byte(`{"priorityClassName": "plex"}`),
+	}
+
+	job, err := generateJob("/", m, []string{"TZ=Europe/Helsinki"}, []string{"a"})
+	if err != nil {
+		t.Fatalf("generateJob() error = %v", err)
+	}
+	spec := job.Spec.Template.Spec
+	if spec.PriorityClassName != "plex" {
+		t.Errorf("PriorityClassName = %s, want the pod template's plex", spec.PriorityClassName)
+	}
+	if len(spec.Tolerations) != 1 || spec.Tolerations[0].Key != "transcode" {
+		t.Errorf("Tolerations = %v, want the defaults", spec.Tolerations)
+	}
+	if spec.NodeSelector["pool"] != "transcode" || spec.NodeSelector["kubernetes.io/arch"] != "amd64" {
+		t.Errorf("NodeSelector = %v, want pool from defaults and arch from annotations", spec.NodeSelector)
+	}
+	if spec.RestartPolicy != corev1.RestartPolicyNever {
+		t.Errorf("RestartPolicy = %s, want Never", spec.RestartPolicy)
+	}
+	if len(spec.Containers) != 2 || spec.Containers[0].Name != m.TranscodeContainerName() || spec.Containers[1].Name != "exporter" {
+		t.Fatalf("Containers = %v, want transcode container and exporter", spec.Containers)
+	}
+	c := spec.Containers[0]
+	if c.Image != m.PmsImage {
+		t.Errorf("transcode image = %s, want %s", c.Image, m.PmsImage)
+	}
+	if cpu, mem := c.Resources.Limits[corev1.ResourceCPU], c.Resources.Limits[corev1.ResourceMemory]; cpu.String() != "2" || mem.String() != "4Gi" {
+		t.Errorf("transcode limits = %v, want cpu from annotations and memory from defaults", c.Resources.Limits)
+	}
+	for _, e := range c.Env {
+		if e.Name == "TZ" && e.Value != "Europe/Helsinki" {
+			t.Errorf("TZ = %s, want the PMS environment", e.Value)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors returned by kube-plex, match these with errors.Is
+var (
+	// ErrPodNotFound is returned when Plex Media Server pod can't be located
+	ErrPodNotFound = errors.New("pod not found")
+	// ErrServiceNotFound is returned when Plex Media Server service can't be located
+	ErrServiceNotFound = errors.New("service not found")
+	// ErrContainerMissing is returned when an expected container is not present in a pod
+	ErrContainerMissing = errors.New("container missing")
+	// ErrImageUnresolved is returned when container status doesn't have an image ID yet
+	ErrImageUnresolved = errors.New("image unresolved")
+	// ErrVolumeMissing is returned when a volume or a volume mount can't be found
+	ErrVolumeMissing = errors.New("volume missing")
+	// ErrInvalidAnnotation is returned when an annotation is missing or can't be parsed
+	ErrInvalidAnnotation = errors.New("invalid annotation")
+	// ErrIncompleteMetadata is returned when the pod builder is given metadata
+	// that hasn't been populated with FetchMetadata
+	ErrIncompleteMetadata = errors.New("incomplete metadata")
+	// ErrQuotaExceeded is returned when a transcode wouldn't fit in the resource
+	// quotas of the namespace
+	ErrQuotaExceeded = errors.New("quota exceeded")
+	// ErrNotScheduled is returned when the transcode pod isn't scheduled within
+	// the scheduling timeout
+	ErrNotScheduled = errors.New("pod not scheduled")
+	// ErrJobsUnavailable is returned when the API server doesn't serve batch/v1
+	// jobs
+	ErrJobsUnavailable = errors.New("jobs API unavailable")
+	// ErrJobsForbidden is returned when RBAC doesn't allow creating the
+	// transcode job
+	ErrJobsForbidden = errors.New("creating jobs forbidden")
+	// ErrNamespaceTerminating is returned when the PMS namespace is being
+	// deleted and no new transcode jobs can be created in it
+	ErrNamespaceTerminating = errors.New("namespace terminating")
+	// ErrCircuitOpen is returned when job creation is paused after repeated
+	// API failures
+	ErrCircuitOpen = errors.New("circuit breaker open")
+	// ErrTooManyTranscodes is returned when the namespace already runs the
+	// maximum number of concurrent transcodes
+	ErrTooManyTranscodes = errors.New("too many transcodes")
+	// ErrImagePull is returned when an image of the transcode pod can't be
+	// pulled
+	ErrImagePull = errors.New("image pull failed")
+	// ErrSharedVolume is returned when the volume the launcher is copied to
+	// isn't shared between the init and the transcode container
+	ErrSharedVolume = errors.New("shared volume misconfigured")
+	// ErrVolumeMount is returned when a volume of the transcode pod can't be
+	// attached or mounted within the volume mount timeout
+	ErrVolumeMount = errors.New("volume mount failed")
+	// ErrOOMKilled is returned when the transcode container was terminated for
+	// running out of memory
+	ErrOOMKilled = errors.New("transcoder OOMKilled")
+	// ErrImageNotAllowed is returned when the transcode pod would run an image
+	// outside of the allowed image prefixes
+	ErrImageNotAllowed = errors.New("image not allowed")
+	// ErrClaimUnbound is returned when a PVC of the transcode pod isn't bound
+	// within the bind timeout
+	ErrClaimUnbound = errors.New("claim not bound")
+	// ErrFeatureDisabled is returned when settings of an experimental feature
+	// are used without enabling the feature
+	ErrFeatureDisabled = errors.New("feature disabled")
+)
+
+// kindError ties an underlying error (e.g. from Kubernetes API) to one of the
+// error values above while keeping the original error available for errors.As
+type kindError struct {
+	kind error
+	err  error
+}
+
+func (e *kindError) Error() string {
+	return fmt.Sprintf("%v: %v", e.kind, e.err)
+}
+
+// Is matches the error kind, the wrapped error is matched through Unwrap
+func (e *kindError) Is(target error) bool {
+	return target == e.kind
+}
+
+func (e *kindError) Unwrap() error {
+	return e.err
+}
+
+// wrapError returns err wrapped with the given kind
+func wrapError(kind, err error) error {
+	return &kindError{kind: kind, err: err}
+}
+
+// annotationError returns an ErrInvalidAnnotation error for the annotation
+func annotationError(annotation, format string, args ...interface{}) error {
+	return fmt.Errorf("%w %s: %s", ErrInvalidAnnotation, annotation, fmt.Sprintf(format, args...))
+}
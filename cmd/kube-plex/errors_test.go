@@ -0,0 +1,34 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func Test_wrapError(t *testing.T) {
+	apiErr := apierrors.NewNotFound(schema.GroupResource{Resource: "pods"}, "pms")
+	err := wrapError(ErrPodNotFound, apiErr)
+
+	if !errors.Is(err, ErrPodNotFound) {
+		t.Errorf("wrapError() = %v, does not match kind %v", err, ErrPodNotFound)
+	}
+	if errors.Is(err, ErrContainerMissing) {
+		t.Errorf("wrapError() = %v, unexpectedly matches %v", err, ErrContainerMissing)
+	}
+	if !apierrors.IsNotFound(err) {
+		t.Errorf("wrapError() = %v, wrapped API error is not reachable", err)
+	}
+}
+
+func Test_annotationError(t *testing.T) {
+	err := annotationError("kube-plex/foo", "bad value `%s`", "bar")
+	if !errors.Is(err, ErrInvalidAnnotation) {
+		t.Errorf("annotationError() = %v, does not match %v", err, ErrInvalidAnnotation)
+	}
+	if want := "invalid annotation kube-plex/foo: bad value `bar`"; err.Error() != want {
+		t.Errorf("annotationError() = %v, want %v", err, want)
+	}
+}
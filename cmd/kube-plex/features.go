@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Experimental features, enabled cluster wide by listing them in
+// KUBE_PLEX_FEATURES. Features are off by default, using their settings fails
+// the transcode until they are enabled.
+//
+// A behavior is experimental when what it does to a transcode isn't fixed by
+// its settings: it reruns a failed transcode, it changes the jobs of every PMS
+// instance from one shared object, or it infers scheduling from other objects
+// on a best effort basis. Settings that make a fixed change to the job or to
+// the API requests of kube-plex, and fail the transcode when they can't be
+// applied, aren't gated.
+const (
+	// featureOOMAutoBump retries OOMKilled transcodes, see runTranscodeOOMRetry
+	featureOOMAutoBump = "OOMAutoBump"
+	// featurePodDefaults loads pod spec defaults from
+	// KUBE_PLEX_DEFAULTS_CONFIGMAP
+	featurePodDefaults = "PodDefaults"
+	// featureDataLocality prefers nodes holding the data of mounted PVCs
+	featureDataLocality = "DataLocality"
+)
+
+// knownFeatures are the features that can be enabled
+var knownFeatures = map[string]bool{
+	featureOOMAutoBump:  true,
+	featurePodDefaults:  true,
+	featureDataLocality: true,
+}
+
+// featureGates are the enabled experimental features
+type featureGates map[string]bool
+
+// parseFeatureGates parses a comma separated list of features to enable.
+// Unknown features are rejected, so that typos don't go unnoticed.
+func parseFeatureGates(s string) (featureGates, error) {
+	g := featureGates{}
+	if strings.TrimSpace(s) == "" {
+		return g, nil
+	}
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		if !knownFeatures[f] {
+			known := make([]string, 0, len(knownFeatures))
+			for k := range knownFeatures {
+				known = append(known, k)
+			}
+			sort.Strings(known)
+			return nil, fmt.Errorf("unknown feature `%s`, expecting one of %s", f, strings.Join(known, ", "))
+		}
+		g[f] = true
+	}
+	return g, nil
+}
+
+// Enabled returns true if the feature is enabled, all features are disabled
+// with nil gates
+func (g featureGates) Enabled(feature string) bool {
+	return g[feature]
+}
+
+// featureSettings returns the settings of experimental features used by the
+// PMS instance, by feature
+func featureSettings(m PmsMetadata, getenv func(string) string) map[string]string {
+	used := map[string]string{}
+	if m.OOMBump > 0 {
+		used[featureOOMAutoBump] = kubePlexOOMBump
+	}
+	if getenv("KUBE_PLEX_DEFAULTS_CONFIGMAP") != "" {
+		used[featurePodDefaults] = "KUBE_PLEX_DEFAULTS_CONFIGMAP"
+	}
+	if m.DataLocality {
+		used[featureDataLocality] = kubePlexDataLocality
+	}
+	return used
+}
+
+// Check fails if any of the used settings, by feature, belong to a disabled
+// feature. Ignoring them would silently change the transcode.
+func (g featureGates) Check(used map[string]string) error {
+	features := make([]string, 0, len(used))
+	for f := range used {
+		features = append(features, f)
+	}
+	sort.Strings(features)
+	for _, f := range features {
+		if !g.Enabled(f) {
+			return fmt.Errorf("%w: %s needs the %s feature, enable it in KUBE_PLEX_FEATURES", ErrFeatureDisabled, used[f], f)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func Test_parseFeatureGates(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    featureGates
+		wantErr bool
+	}{
+		{"unset", "", featureGates{}, false},
+		{"single", "OOMAutoBump", featureGates{featureOOMAutoBump: true}, false},
+		{"multiple", "OOMAutoBump, PodDefaults", featureGates{featureOOMAutoBump: true, featurePodDefaults: true}, false},
+		{"data locality", "DataLocality", featureGates{featureDataLocality: true}, false},
+		{"unknown", "OOMAutoBump,Teleport", nil, true},
+		{"case sensitive", "oomautobump", nil, true},
+		{"empty name", "OOMAutoBump,,PodDefaults", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseFeatureGates(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseFeatureGates() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseFeatureGates() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_featureGates_Enabled(t *testing.T) {
+	g, err := parseFeatureGates("PodDefaults")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !g.Enabled(featurePodDefaults) {
+		t.Errorf("Enabled(%s) = false, want true", featurePodDefaults)
+	}
+	if g.Enabled(featureOOMAutoBump) {
+		t.Errorf("Enabled(%s) = true, want false", featureOOMAutoBump)
+	}
+	var none featureGates
+	if none.Enabled(featurePodDefaults) {
+		t.Errorf("nil gates Enabled(%s) = true, want false", featurePodDefaults)
+	}
+}
+
+func Test_featureSettings(t *testing.T) {
+	env := func(v string) func(string) string {
+		return func(k string) string {
+			if k == "KUBE_PLEX_DEFAULTS_CONFIGMAP" {
+				return v
+			}
+			return ""
+		}
+	}
+	tests := []struct {
+		name string
+		m    PmsMetadata
+		env  func(string) string
+		want map[string]string
+	}{
+		{"none", PmsMetadata{}, env(""), map[string]string{}},
+		{"oom auto bump", PmsMetadata{OOMBump: 1.5}, env(""), map[string]string{featureOOMAutoBump: kubePlexOOMBump}},
+		{"pod defaults", PmsMetadata{}, env("plex-defaults"), map[string]string{featurePodDefaults: "KUBE_PLEX_DEFAULTS_CONFIGMAP"}},
+		{"data locality", PmsMetadata{DataLocality: true}, env(""), map[string]string{featureDataLocality: kubePlexDataLocality}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := featureSettings(tt.m, tt.env); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("featureSettings() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_featureGates_Check(t *testing.T) {
+	used := map[string]string{featureOOMAutoBump: kubePlexOOMBump, featureDataLocality: kubePlexDataLocality}
+	tests := []struct {
+		name    string
+		g       featureGates
+		used    map[string]string
+		wantErr error
+	}{
+		{"nothing used", nil, map[string]string{}, nil},
+		{"all enabled", featureGates{featureOOMAutoBump: true, featureDataLocality: true}, used, nil},
+		{"one disabled", featureGates{featureOOMAutoBump: true}, used, ErrFeatureDisabled},
+		{"none enabled", nil, used, ErrFeatureDisabled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.g.Check(tt.used); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Check() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
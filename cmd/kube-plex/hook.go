@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+
+	batch "k8s.io/api/batch/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+// defaultHookTimeout bounds the post hook unless KUBE_PLEX_POST_HOOK_TIMEOUT
+// is set
+const defaultHookTimeout = time.Minute
+
+// postHook is a shell command executed after a successful transcode
+type postHook struct {
+	command string
+	timeout time.Duration
+}
+
+// hookSession describes the finished transcode to the post hook
+type hookSession struct {
+	Namespace string
+	Job       string
+	Pod       string
+	ExitCode  int32
+	Duration  time.Duration
+}
+
+// parsePostHook returns the post hook for a command and an optional timeout,
+// nil is returned when no command is set
+func parsePostHook(command, timeout string) (*postHook, error) {
+	if command == "" {
+		return nil, nil
+	}
+	h := &postHook{command: command, timeout: defaultHookTimeout}
+	if timeout != "" {
+		d, err := time.ParseDuration(timeout)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid post hook timeout `%s`", timeout)
+		}
+		h.timeout = d
+	}
+	return h, nil
+}
+
+// newHookSession collects the session details of a finished job. Pod details
+// are left empty if the pod can't be found.
+func newHookSession(ctx context.Context, cl kubernetes.Interface, m PmsMetadata, job *batch.Job, d time.Duration) hookSession {
+	s := hookSession{Namespace: job.Namespace, Job: job.Name, Duration: d}
+	pods, err := jobPods(ctx, cl, job)
+	if err != nil {
+		klog.Errorf("Unable to find transcode pod for post hook: %v", err)
+		return s
+	}
+	for _, p := range pods {
+		if c, ok := containerExitCode(p, m.TranscodeContainerName()); ok {
+			s.Pod, s.ExitCode = p.Name, c
+			break
+		}
+	}
+	return s
+}
+
+// env returns the environment of the hook process, describing the session
+func (s hookSession) env() []string {
+	return []string{
+		"KUBE_PLEX_NAMESPACE=" + s.Namespace,
+		"KUBE_PLEX_JOB_NAME=" + s.Job,
+		"KUBE_PLEX_POD_NAME=" + s.Pod,
+		"KUBE_PLEX_EXIT_CODE=" + strconv.Itoa(int(s.ExitCode)),
+		"KUBE_PLEX_DURATION=" + strconv.FormatFloat(s.Duration.Seconds(), 'f', 0, 64),
+	}
+}
+
+// run executes the hook. Errors are only logged, the hook doesn't change the
+// result of the transcode.
+func (h *postHook) run(ctx context.Context, s hookSession) {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	// Output is collected to a file: with a pipe, processes started by the
+	// command would keep it open and delay returning past the timeout
+	f, err := os.CreateTemp("", "kube-plex-hook-")
+	if err != nil {
+		klog.Errorf("Unable to create post hook output file: %v", err)
+		return
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", h.command)
+	cmd.Env = append(os.Environ(), s.env()...)
+	cmd.Stdout = f
+	cmd.Stderr = f
+	err = cmd.Run()
+	out, _ := os.ReadFile(f.Name())
+	if ctx.Err() == context.DeadlineExceeded {
+		klog.Errorf("Post hook timed out after %v, output: %s", h.timeout, out)
+		return
+	}
+	if err != nil {
+		klog.Errorf("Post hook failed: %v, output: %s", err, out)
+		return
+	}
+	klog.V(1).Infof("Post hook completed, output: %s", out)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	batch "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func Test_parsePostHook(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		timeout string
+		want    *postHook
+		wantErr bool
+	}{
+		{"no hook", "", "10s", nil, false},
+		{"default timeout", "notify", "", &postHook{command: "notify", timeout: defaultHookTimeout}, false},
+		{"custom timeout", "notify", "10s", &postHook{command: "notify", timeout: 10 * time.Second}, false},
+		{"invalid timeout", "notify", "soon", nil, true},
+		{"zero timeout", "notify", "0s", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parsePostHook(tt.command, tt.timeout)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parsePostHook() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parsePostHook() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_postHook_run(t *testing.T) {
+	ctx := context.Background()
+	out := filepath.Join(t.TempDir(), "env")
+	s := hookSession{Namespace: "plex", Job: "job", Pod: "job-abc", ExitCode: 0, Duration: 90 * time.Second}
+
+	h := &postHook{command: "env > " + out, timeout: 10 * time.Second}
+	h.run(ctx, s)
+
+	b, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("hook did not run: %v", err)
+	}
+	for _, e := range s.env() {
+		if !strings.Contains(string(b), e+"\n") {
+			t.Errorf("hook environment is missing %s", e)
+		}
+	}
+
+	t.Run("timeout", func(t *testing.T) {
+		h := &postHook{command: "sleep 10", timeout: 50 * time.Millisecond}
+		start := time.Now()
+		h.run(ctx, s)
+		if d := time.Since(start); d > 5*time.Second {
+			t.Errorf("hook ran for %v, expected to be stopped after %v", d, h.timeout)
+		}
+	})
+}
+
+func Test_newHookSession(t *testing.T) {
+	ctx := context.Background()
+	job := &batch.Job{ObjectMeta: metav1.ObjectMeta{Name: "job", Namespace: "plex"}}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "job-abc", Namespace: "plex", Labels: map[string]string{"job-name": "job"}},
+		Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{
+			{Name: "plex", State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 3}}},
+		}},
+	}
+	other := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "plex", Labels: map[string]string{"job-name": "other"}}}
+	cl := fake.NewSimpleClientset(pod, other)
+
+	want := hookSession{Namespace: "plex", Job: "job", Pod: "job-abc", ExitCode: 3, Duration: time.Minute}
+	if got := newHookSession(ctx, cl, PmsMetadata{}, job, time.Minute); !reflect.DeepEqual(got, want) {
+		t.Errorf("newHookSession() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// parseImagePrefixes parses the comma separated list of image prefixes
+// transcode pods may run, empty if all images are allowed
+func parseImagePrefixes(s string) ([]string, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	var prefixes []string
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			return nil, fmt.Errorf("empty image prefix in `%s`", s)
+		}
+		prefixes = append(prefixes, p)
+	}
+	return prefixes, nil
+}
+
+// checkImages returns ErrImageNotAllowed if a container of spec runs an image
+// that doesn't start with one of prefixes. All images are allowed without
+// prefixes.
+func checkImages(spec corev1.PodSpec, prefixes []string) error {
+	if len(prefixes) == 0 {
+		return nil
+	}
+	for _, c := range append(append([]corev1.Container{}, spec.InitContainers...), spec.Containers...) {
+		if !hasImagePrefix(c.Image, prefixes) {
+			return fmt.Errorf("%w: image %s of container %s doesn't match the allowed prefixes %s", ErrImageNotAllowed, c.Image, c.Name, strings.Join(prefixes, ", "))
+		}
+	}
+	return nil
+}
+
+// hasImagePrefix returns true if image starts with one of prefixes
+func hasImagePrefix(image string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(image, p) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func Test_parseImagePrefixes(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    []string
+		wantErr bool
+	}{
+		{"unset", "", nil, false},
+		{"single", "registry.example.com/", []string{"registry.example.com/"}, false},
+		{"multiple", "registry.example.com/plex/, docker.io/plexinc/", []string{"registry.example.com/plex/", "docker.io/plexinc/"}, false},
+		{"empty prefix", "registry.example.com/,,docker.io/", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseImagePrefixes(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseImagePrefixes() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseImagePrefixes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_checkImages(t *testing.T) {
+	spec := func(init, transcode string) corev1.PodSpec {
+		return corev1.PodSpec{
+			InitContainers: []corev1.Container{{Name: "kube-plex-init", Image: init}},
+			Containers:     []corev1.Container{{Name: "plex", Image: transcode}},
+		}
+	}
+	prefixes := []string{"registry.example.com/", "docker.io/plexinc/pms-docker@"}
+	tests := []struct {
+		name     string
+		spec     corev1.PodSpec
+		prefixes []string
+		wantErr  error
+	}{
+		{"all allowed without prefixes", spec("kubeplex@sha256:12345", "pms@sha256:12345"), nil, nil},
+		{"allowed", spec("registry.example.com/kube-plex@sha256:12345", "docker.io/plexinc/pms-docker@sha256:12345"), prefixes, nil},
+		{"transcode image disallowed", spec("registry.example.com/kube-plex@sha256:12345", "docker.io/linuxserver/plex@sha256:12345"), prefixes, ErrImageNotAllowed},
+		{"init image disallowed", spec("ghcr.io/kube-plex@sha256:12345", "registry.example.com/pms@sha256:12345"), prefixes, ErrImageNotAllowed},
+		{"prefix of registry host", spec("registry.example.com.evil/kube-plex", "registry.example.com/pms"), prefixes, ErrImageNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkImages(tt.spec, tt.prefixes); !errors.Is(err, tt.wantErr) {
+				t.Errorf("checkImages() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
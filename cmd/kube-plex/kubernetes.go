@@ -2,73 +2,381 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"path"
 	"strings"
 	"time"
 
 	"github.com/munnerz/kube-plex/internal/ffmpeg"
+	"go.opentelemetry.io/otel/trace"
 	batch "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/discovery"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/klog/v2"
 )
 
 func generateJob(cwd string, m PmsMetadata, env []string, args []string) (*batch.Job, error) {
-	envVars := filterPodEnv(toCoreV1EnvVar(env))
+	envVars := withEnv(filterPodEnv(toCoreV1EnvVar(env)), m.ProxyEnv)
 	var ttl, backoff int32
 	ttl = int32((24 * time.Hour).Seconds())
 	backoff = 1
 	ownerRef, err := m.OwnerReference()
 	if err != nil {
-		return &batch.Job{}, fmt.Errorf("error generating owner reference: %v", err)
+		return &batch.Job{}, fmt.Errorf("error generating owner reference: %w", err)
+	}
+	ownerRefs := []metav1.OwnerReference{ownerRef}
+	if m.NoOwnerReference {
+		ownerRefs = nil
+	}
+
+	var deadline *int64
+	if m.MaxLifetime > 0 {
+		d := int64(m.MaxLifetime.Seconds())
+		deadline = &d
+	}
+
+	// Host networking shares the network namespace of the node with the
+	// transcoder. The launcher port is declared as a host port, so that the
+	// scheduler doesn't place the pod on a node where PMS or another transcode
+	// on the host network already uses it.
+	var dnsPolicy corev1.DNSPolicy
+	var ports []corev1.ContainerPort
+	if m.HostNetwork {
+		dnsPolicy = corev1.DNSClusterFirstWithHostNet
+		ports = []corev1.ContainerPort{{Name: "launcher", ContainerPort: launcherPort, HostPort: launcherPort, Protocol: corev1.ProtocolTCP}}
+	}
+
+	// Transcode pods run the PMS image, which has to be built for the node
+	var nodeSelector map[string]string
+	arch := defaultArch
+	if m.Arch != nil {
+		arch = *m.Arch
+	}
+	if arch != "" {
+		nodeSelector = map[string]string{corev1.LabelArchStable: arch}
+	}
+	nodeSelector = mergeMaps(nodeSelector, m.NodeSelector)
+
+	outVolumes, outMounts := m.OutputVolume()
+	mounts := append(append([]corev1.VolumeMount{{Name: "shared", MountPath: "/shared"}}, m.VolumeMounts...), outMounts...)
+
+	// Devices are only available to the transcoder
+	devVolumes, devMounts := m.DeviceVolumes()
+	var podSecurity *corev1.PodSecurityContext
+	if m.RenderGroup != nil {
+		podSecurity = &corev1.PodSecurityContext{SupplementalGroups: []int64{*m.RenderGroup}}
+	}
+	if len(m.Sysctls) > 0 {
+		if podSecurity == nil {
+			podSecurity = &corev1.PodSecurityContext{}
+		}
+		podSecurity.Sysctls = m.Sysctls
+	}
+
+	image := m.PmsImage
+	if m.UseInitImage {
+		image = m.KubePlexImage
 	}
 
-	return &batch.Job{
+	// The sidecar sees the same files as the transcoder, see parseSidecar for
+	// the completion semantics
+	containers := []corev1.Container{{
+		Name:                     m.TranscodeContainerName(),
+		Command:                  m.LauncherCmd(args...),
+		Image:                    image,
+		Env:                      envVars,
+		WorkingDir:               cwd,
+		Ports:                    ports,
+		VolumeMounts:             append(append([]corev1.VolumeMount{}, mounts...), devMounts...),
+		Resources:                m.TranscodeResources(args),
+		SecurityContext:          m.SecurityContext,
+		Lifecycle:                m.Lifecycle,
+		Stdin:                    m.DebugStdin,
+		TTY:                      m.DebugTTY,
+		TerminationMessagePolicy: m.TermMsgPolicy,
+		TerminationMessagePath:   m.TermMsgPath,
+	}}
+	if m.Sidecar != nil {
+		s := *m.Sidecar
+		s.WorkingDir = cwd
+		s.VolumeMounts = mounts
+		s.Env = append(s.Env, corev1.EnvVar{Name: "KUBE_PLEX_DONE_FILE", Value: m.completionFile()})
+		containers = append(containers, s)
+	}
+
+	job := &batch.Job{
 		TypeMeta: metav1.TypeMeta{},
 		ObjectMeta: metav1.ObjectMeta{
 			GenerateName:    "pms-elastic-transcoder-",
 			Namespace:       m.Namespace,
-			OwnerReferences: []metav1.OwnerReference{ownerRef},
+			OwnerReferences: ownerRefs,
 		},
 		Spec: batch.JobSpec{
 			BackoffLimit:            &backoff,
+			ActiveDeadlineSeconds:   deadline,
 			TTLSecondsAfterFinished: &ttl,
 			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels:      m.PodLabels,
+					Annotations: m.PodAnnotations,
+				},
 				Spec: corev1.PodSpec{
-					NodeSelector: map[string]string{
-						"kubernetes.io/arch": "amd64",
-					},
-					RestartPolicy: corev1.RestartPolicyNever,
-					Containers: []corev1.Container{
-						{
-							Name:       "plex",
-							Command:    m.LauncherCmd(args...),
-							Image:      m.PmsImage,
-							Env:        envVars,
-							WorkingDir: cwd,
-							VolumeMounts: append(
-								[]corev1.VolumeMount{{Name: "shared", MountPath: "/shared"}},
-								m.VolumeMounts...,
-							),
-							Resources: m.ResourceRequirements(),
-						},
-					},
+					NodeSelector:          nodeSelector,
+					RestartPolicy:         corev1.RestartPolicyNever,
+					HostNetwork:           m.HostNetwork,
+					DNSPolicy:             dnsPolicy,
+					Overhead:              m.PodOverhead,
+					EnableServiceLinks:    m.ServiceLinks,
+					ShareProcessNamespace: m.SharePID,
+					SecurityContext:       podSecurity,
+					Hostname:              m.Hostname,
+					Subdomain:             m.Subdomain,
+					PreemptionPolicy:      m.Preemption,
+					RuntimeClassName:      m.RuntimeClass,
+					Containers:            append(containers, m.ExtraContainers...),
 					InitContainers: []corev1.Container{{
 						Name:         "kube-plex-init",
 						Image:        m.KubePlexImage,
 						Command:      []string{"cp", "/transcode-launcher", "/shared/transcode-launcher"},
 						VolumeMounts: []corev1.VolumeMount{{Name: "shared", MountPath: "/shared", ReadOnly: false}},
 					}},
-					Volumes: append(
-						[]corev1.Volume{{Name: "shared", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}}},
-						m.Volumes...,
+					Volumes: append(append(append(
+						[]corev1.Volume{{Name: "shared", VolumeSource: m.ScratchVolumeSource()}},
+						m.Volumes...),
+						outVolumes...),
+						devVolumes...,
 					),
 				},
 			},
 		},
-	}, nil
+	}
+
+	// Defaults are overridden by the settings from annotations, the pod
+	// template overrides both
+	if len(m.PodDefaults) > 0 {
+		spec, err := mergePodDefaults(job.Spec.Template.Spec, m.PodDefaults)
+		if err != nil {
+			return &batch.Job{}, fmt.Errorf("error applying pod defaults: %w", err)
+		}
+		job.Spec.Template.Spec = spec
+	}
+	if len(m.PodTemplate) > 0 {
+		spec, err := mergePodTemplate(job.Spec.Template.Spec, m.PodTemplate)
+		if err != nil {
+			return &batch.Job{}, fmt.Errorf("error applying pod template: %w", err)
+		}
+		job.Spec.Template.Spec = spec
+	}
+	// After the pod template, which would replace the preferred terms
+	if len(m.Locality) > 0 {
+		preferNodes(&job.Spec.Template.Spec, m.Locality)
+	}
+	if m.AvoidNode != "" {
+		avoidNode(&job.Spec.Template.Spec, m.AvoidNode)
+	}
+	if m.TokenExpiry > 0 {
+		setTokenExpiry(&job.Spec.Template.Spec, m.TokenExpiry)
+	}
+	if err := validateSharedVolume(job.Spec.Template.Spec, m.TranscodeContainerName()); err != nil {
+		return &batch.Job{}, err
+	}
+	return job, nil
+}
+
+// setTokenExpiry sets the expiration of the service account tokens projected
+// into the volumes of spec, e.g. from the pod template. Volumes are copied
+// before the change, those from the PMS pod share their sources with it.
+func setTokenExpiry(spec *corev1.PodSpec, seconds int64) {
+	for i, v := range spec.Volumes {
+		if v.Projected == nil {
+			continue
+		}
+		v = *v.DeepCopy()
+		for _, src := range v.Projected.Sources {
+			if src.ServiceAccountToken != nil {
+				src.ServiceAccountToken.ExpirationSeconds = &seconds
+			}
+		}
+		spec.Volumes[i] = v
+	}
+}
+
+// validateSharedVolume checks that the shared volume is mounted writable at
+// /shared in both the init container, which copies the launcher there, and the
+// transcode container, which runs it. A conflicting volume or mount from the
+// PMS pod would otherwise leave the launcher missing in the transcode pod.
+func validateSharedVolume(spec corev1.PodSpec, transcoder string) error {
+	n := 0
+	for _, v := range spec.Volumes {
+		if v.Name == "shared" {
+			n++
+		}
+	}
+	if n != 1 {
+		return fmt.Errorf("%w: %d volumes named shared, expecting one", ErrSharedVolume, n)
+	}
+	if err := checkSharedMount(spec.InitContainers, "kube-plex-init"); err != nil {
+		return err
+	}
+	return checkSharedMount(spec.Containers, transcoder)
+}
+
+// checkSharedMount checks the mounts of the named container for
+// validateSharedVolume
+func checkSharedMount(containers []corev1.Container, name string) error {
+	for _, c := range containers {
+		if c.Name != name {
+			continue
+		}
+		mounted := false
+		for _, vm := range c.VolumeMounts {
+			switch {
+			case vm.Name == "shared" && vm.MountPath == "/shared" && !vm.ReadOnly && vm.SubPath == "" && vm.SubPathExpr == "":
+				mounted = true
+			case vm.Name == "shared" || path.Clean(vm.MountPath) == "/shared":
+				return fmt.Errorf("%w: container %s mounts volume %s at %s", ErrSharedVolume, name, vm.Name, vm.MountPath)
+			}
+		}
+		if !mounted {
+			return fmt.Errorf("%w: container %s doesn't mount the shared volume at /shared", ErrSharedVolume, name)
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: container %s not found in transcode pod", ErrContainerMissing, name)
+}
+
+// avoidNode adds a preferred node anti-affinity against the named node to
+// spec, keeping any affinity already set
+func avoidNode(spec *corev1.PodSpec, node string) {
+	preferNodes(spec, []corev1.NodeSelectorTerm{{MatchFields: []corev1.NodeSelectorRequirement{{
+		Key:      "metadata.name",
+		Operator: corev1.NodeSelectorOpNotIn,
+		Values:   []string{node},
+	}}}})
+}
+
+// preferNodes adds each of terms as a preferred node affinity term to spec,
+// keeping any affinity already set
+func preferNodes(spec *corev1.PodSpec, terms []corev1.NodeSelectorTerm) {
+	if spec.Affinity == nil {
+		spec.Affinity = &corev1.Affinity{}
+	}
+	if spec.Affinity.NodeAffinity == nil {
+		spec.Affinity.NodeAffinity = &corev1.NodeAffinity{}
+	}
+	na := spec.Affinity.NodeAffinity
+	for _, t := range terms {
+		na.PreferredDuringSchedulingIgnoredDuringExecution = append(na.PreferredDuringSchedulingIgnoredDuringExecution, corev1.PreferredSchedulingTerm{
+			Weight:     100,
+			Preference: t,
+		})
+	}
+}
+
+// mergePodTemplate applies a pod spec fragment to spec as a strategic merge
+// patch, i.e. containers are merged by name and volumes by name. The parts
+// kube-plex relies on are restored from spec afterwards: the restart policy,
+// the init container, the transcode container command and image, and the
+// shared volume and its mount. spec is expected to be generated by generateJob,
+// with the transcode container and the shared volume first.
+func mergePodTemplate(spec corev1.PodSpec, tmpl []byte) (corev1.PodSpec, error) {
+	orig, err := json.Marshal(spec)
+	if err != nil {
+		return corev1.PodSpec{}, err
+	}
+	patched, err := strategicpatch.StrategicMergePatch(orig, tmpl, corev1.PodSpec{})
+	if err != nil {
+		return corev1.PodSpec{}, err
+	}
+	return restorePodSpec(patched, spec)
+}
+
+// mergePodDefaults applies spec as a strategic merge patch to a pod spec
+// fragment of defaults, so that the fields set in spec win. The parts kube-plex
+// relies on are restored as with mergePodTemplate.
+func mergePodDefaults(spec corev1.PodSpec, defaults []byte) (corev1.PodSpec, error) {
+	orig, err := json.Marshal(spec)
+	if err != nil {
+		return corev1.PodSpec{}, err
+	}
+	patched, err := strategicpatch.StrategicMergePatch(defaults, orig, corev1.PodSpec{})
+	if err != nil {
+		return corev1.PodSpec{}, err
+	}
+	return restorePodSpec(patched, spec)
+}
+
+// restorePodSpec decodes a merged pod spec and restores the parts of spec
+// kube-plex relies on
+func restorePodSpec(patched []byte, spec corev1.PodSpec) (corev1.PodSpec, error) {
+	var out corev1.PodSpec
+	if err := json.Unmarshal(patched, &out); err != nil {
+		return corev1.PodSpec{}, err
+	}
+
+	out.RestartPolicy = spec.RestartPolicy
+	out.InitContainers = restoreContainers(out.InitContainers, spec.InitContainers)
+
+	tc := spec.Containers[0]
+	found := false
+	for i := range out.Containers {
+		c := &out.Containers[i]
+		if c.Name == tc.Name {
+			c.Command, c.Args, c.Image = tc.Command, nil, tc.Image
+			c.VolumeMounts = restoreSharedMount(c.VolumeMounts)
+			found = true
+		}
+	}
+	if !found {
+		return corev1.PodSpec{}, fmt.Errorf("transcode container %s removed", tc.Name)
+	}
+
+	shared := spec.Volumes[0]
+	volumes := []corev1.Volume{shared}
+	for _, v := range out.Volumes {
+		if v.Name != shared.Name {
+			volumes = append(volumes, v)
+		}
+	}
+	out.Volumes = volumes
+	return out, nil
+}
+
+// restoreContainers replaces the containers in patched that are defined in
+// orig, containers removed by the patch are added back
+func restoreContainers(patched, orig []corev1.Container) []corev1.Container {
+	out := make([]corev1.Container, 0, len(orig)+len(patched))
+	out = append(out, orig...)
+	for _, c := range patched {
+		found := false
+		for _, o := range orig {
+			found = found || o.Name == c.Name
+		}
+		if !found {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// restoreSharedMount makes sure the shared volume is mounted at /shared
+func restoreSharedMount(mounts []corev1.VolumeMount) []corev1.VolumeMount {
+	out := []corev1.VolumeMount{{Name: "shared", MountPath: "/shared"}}
+	for _, vm := range mounts {
+		if vm.Name != "shared" && vm.MountPath != "/shared" {
+			out = append(out, vm)
+		}
+	}
+	return out
 }
 
 func toCoreV1EnvVar(in []string) []corev1.EnvVar {
@@ -99,45 +407,258 @@ func filterPodEnv(in []corev1.EnvVar) []corev1.EnvVar {
 	return out
 }
 
-func waitForPodCompletion(ctx context.Context, cl kubernetes.Interface, job *batch.Job) error {
-	w, err := cl.BatchV1().Jobs(job.Namespace).Watch(ctx, metav1.SingleObject(job.ObjectMeta))
-	if err != nil {
-		return fmt.Errorf("failed to watch job: %v", err)
+// withEnv returns in with the variables in env replacing those of the same
+// name, or added at the end
+func withEnv(in, env []corev1.EnvVar) []corev1.EnvVar {
+	if len(env) == 0 {
+		return in
+	}
+	replaced := map[string]bool{}
+	for _, v := range env {
+		replaced[v.Name] = true
+	}
+	out := []corev1.EnvVar{}
+	for _, v := range in {
+		if !replaced[v.Name] {
+			out = append(out, v)
+		}
 	}
-	defer w.Stop()
+	return append(out, env...)
+}
 
-	// Check job state once before starting wait
-	j, err := cl.BatchV1().Jobs(job.Namespace).Get(ctx, job.Name, metav1.GetOptions{})
-	if err != nil {
-		return fmt.Errorf("unable to fetch job information for checking: %v", err)
+// generateNameRetries is the number of job creations retried after the
+// generated name of the job already exists
+const generateNameRetries = 3
+
+// createJob creates the transcode job, once the API server is known to serve
+// jobs. Discovery errors are logged and the job is created regardless, so that
+// the API errors of the create are returned. ErrJobsForbidden is returned when
+// RBAC denies the create. Collisions of generated names are retried.
+func createJob(ctx context.Context, cl kubernetes.Interface, job *batch.Job) (*batch.Job, error) {
+	if err := checkJobsAPI(cl.Discovery()); errors.Is(err, ErrJobsUnavailable) {
+		return nil, err
+	} else if err != nil {
+		klog.Errorf("Unable to discover the jobs API: %v", err)
+	}
+	j, err := cl.BatchV1().Jobs(job.Namespace).Create(ctx, job, metav1.CreateOptions{})
+	// A generated name can collide with an existing job, a new name is
+	// generated on each attempt
+	for i := 0; i < generateNameRetries && apierrors.IsAlreadyExists(err) && job.Name == "" && job.GenerateName != ""; i++ {
+		klog.V(1).Infof("Generated job name collided, retrying: %v", err)
+		j, err = cl.BatchV1().Jobs(job.Namespace).Create(ctx, job, metav1.CreateOptions{})
+	}
+	// The API server refuses new objects in a terminating namespace with
+	// Forbidden, which isn't an RBAC issue
+	if apierrors.HasStatusCause(err, corev1.NamespaceTerminatingCause) {
+		return nil, fmt.Errorf("%w: namespace %s is being deleted, transcodes can't be started in it: %v", ErrNamespaceTerminating, job.Namespace, err)
 	}
+	// Quota and admission plugins deny with Forbidden too, only authorizer
+	// denials are missing permissions
+	if apierrors.IsForbidden(err) && strings.Contains(err.Error(), `cannot create resource "jobs"`) {
+		return nil, fmt.Errorf("%w: the `create` verb on `jobs` in the `batch` API group is required in namespace %s, check the kube-plex role: %v", ErrJobsForbidden, job.Namespace, err)
+	}
+	return j, err
+}
 
-	if done, err := jobDone(j); done {
+// checkJobsAPI returns ErrJobsUnavailable if jobs can't be created with the
+// batch/v1 API, the only version of jobs in the client
+func checkJobsAPI(d discovery.DiscoveryInterface) error {
+	gv := batch.SchemeGroupVersion.String()
+	rl, err := d.ServerResourcesForGroupVersion(gv)
+	if apierrors.IsNotFound(err) {
+		return fmt.Errorf("%w: %s is not served by the API server, transcodes have to run locally", ErrJobsUnavailable, gv)
+	}
+	if err != nil {
 		return err
 	}
+	for _, r := range rl.APIResources {
+		if r.Name != "jobs" {
+			continue
+		}
+		for _, v := range r.Verbs {
+			if v == "create" {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("%w: creating jobs is not supported by %s, transcodes have to run locally", ErrJobsUnavailable, gv)
+}
+
+// errWatchClosed is returned by podWatcher when the watch ends before the job
+// has completed. This happens routinely for long transcodes when the API server
+// times out the watch or the connection drops.
+var errWatchClosed = errors.New("watch closed before job completed")
+
+// Delays between reconnects of a closed job watch. The delay doubles for each
+// watch that closes sooner than watchBackoffMax after it was started, so that
+// an API server or proxy cutting watches right away isn't hit by a tight loop
+// of requests from every session.
+const (
+	watchBackoffInitial = time.Second
+	watchBackoffMax     = 30 * time.Second
+	watchBackoffJitter  = 0.5
+)
 
-	return podWatcher(ctx, w)
+// waitForPodCompletion waits until the job has either succeeded or failed. The
+// watch is re-established whenever it is closed, each time starting from the
+// latest resourceVersion of the job. A timeout larger than zero sets the server
+// side timeout for a single watch request.
+func waitForPodCompletion(ctx context.Context, cl kubernetes.Interface, job *batch.Job, timeout time.Duration) error {
+	backoff := watchBackoffInitial
+	for {
+		// Check job state before (re)starting the watch, this also catches any
+		// updates missed while we were not watching
+		j, err := cl.BatchV1().Jobs(job.Namespace).Get(ctx, job.Name, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("unable to fetch job information for checking: %v", err)
+		}
+
+		if done, err := jobDone(j); done {
+			return err
+		}
+
+		w, err := cl.BatchV1().Jobs(job.Namespace).Watch(ctx, jobWatchOptions(j, timeout))
+		if err != nil {
+			return fmt.Errorf("failed to watch job: %v", err)
+		}
+
+		started := clk.Now()
+		err = podWatcher(ctx, w)
+		w.Stop()
+		if err != errWatchClosed {
+			return err
+		}
+
+		if clk.Since(started) >= watchBackoffMax {
+			backoff = watchBackoffInitial
+		}
+		d := wait.Jitter(backoff, watchBackoffJitter)
+		klog.V(2).Infof("Watch for job %s closed, reconnecting in %v", job.Name, d)
+		if err := sleep(ctx, d); err != nil {
+			return err
+		}
+		if backoff *= 2; backoff > watchBackoffMax {
+			backoff = watchBackoffMax
+		}
+	}
+}
+
+// sleep waits for d on clk, returning early when ctx is cancelled
+func sleep(ctx context.Context, d time.Duration) error {
+	t := clk.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf("context cancelled: %v", ctx.Err())
+	case <-t.C():
+		return nil
+	}
+}
+
+// Job completion wait strategies, selected with KUBE_PLEX_WAIT_STRATEGY
+const (
+	waitWatch = "watch"
+	waitPoll  = "poll"
+)
+
+// defaultPollInterval is the job poll interval of the poll strategy unless set
+// with KUBE_PLEX_POLL_INTERVAL
+const defaultPollInterval = 5 * time.Second
+
+// parseWaitStrategy returns the poll interval for the given wait strategy,
+// zero when the job is watched
+func parseWaitStrategy(strategy, interval string) (time.Duration, error) {
+	switch strategy {
+	case "", waitWatch:
+		if interval != "" {
+			return 0, fmt.Errorf("poll interval is only used with the %s strategy", waitPoll)
+		}
+		return 0, nil
+	case waitPoll:
+		if interval == "" {
+			return defaultPollInterval, nil
+		}
+		d, err := time.ParseDuration(interval)
+		if err != nil || d <= 0 {
+			return 0, fmt.Errorf("invalid poll interval `%s`", interval)
+		}
+		return d, nil
+	default:
+		return 0, fmt.Errorf("invalid wait strategy `%s`, must be %s or %s", strategy, waitWatch, waitPoll)
+	}
+}
+
+// pollForPodCompletion waits until the job has either succeeded or failed by
+// fetching the job every interval. Polling is simpler than watching and
+// doesn't hold a connection open, at the cost of noticing completion later.
+func pollForPodCompletion(ctx context.Context, cl kubernetes.Interface, job *batch.Job, interval time.Duration) error {
+	t := clk.NewTimer(interval)
+	defer t.Stop()
+	for {
+		j, err := cl.BatchV1().Jobs(job.Namespace).Get(ctx, job.Name, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("unable to fetch job information for checking: %v", err)
+		}
+		if done, err := jobDone(j); done {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("context cancelled: %v", ctx.Err())
+		case <-t.C():
+			t.Reset(interval)
+		}
+	}
+}
+
+// jobWatchOptions returns the options for watching only the given job, starting
+// from its resourceVersion. The field selector on the job name lets the watch
+// be authorized by the `watch` verb alone, without listing other jobs.
+func jobWatchOptions(j *batch.Job, timeout time.Duration) metav1.ListOptions {
+	opts := metav1.ListOptions{
+		FieldSelector:   fields.OneTermEqualSelector("metadata.name", j.Name).String(),
+		ResourceVersion: j.ResourceVersion,
+	}
+	if timeout > 0 {
+		t := int64(timeout.Seconds())
+		opts.TimeoutSeconds = &t
+	}
+	return opts
 }
 
 func podWatcher(ctx context.Context, w watch.Interface) error {
+	active := false
 	for {
 		select {
 		case <-ctx.Done():
 			return fmt.Errorf("context cancelled: %v", ctx.Err())
-		case r := <-w.ResultChan():
+		case r, ok := <-w.ResultChan():
+			if !ok {
+				return errWatchClosed
+			}
 			switch r.Type {
 			case watch.Added:
 			case watch.Modified:
 				j := r.Object.(*batch.Job)
 
 				klog.V(2).Info("received an update")
+				if j.Status.Active > 0 && !active {
+					trace.SpanFromContext(ctx).AddEvent("job active")
+					active = true
+				}
 				if done, err := jobDone(j); done {
 					return err
 				}
 			case watch.Deleted:
 				j := r.Object.(*batch.Job)
-				klog.Error("Job %s deleted while waiting for it to complete!", j.Name)
+				klog.Errorf("Job %s deleted while waiting for it to complete!", j.Name)
 				return fmt.Errorf("job %s deleted unexpectedly", j.Name)
+			case watch.Error:
+				// Errors such as an expired resourceVersion are resolved by
+				// fetching the current state and watching again
+				klog.V(2).Infof("Watch returned an error: %v", r.Object)
+				return errWatchClosed
 			}
 		}
 	}
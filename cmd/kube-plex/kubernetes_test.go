@@ -2,15 +2,24 @@ package main
 
 import (
 	"context"
+	"errors"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/go-test/deep"
 	batch "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	fakediscovery "k8s.io/client-go/discovery/fake"
 	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
 )
 
 func Test_toCoreV1EnvVar(t *testing.T) {
@@ -56,6 +65,171 @@ func Test_filterPodEnv(t *testing.T) {
 	}
 }
 
+func Test_withEnv(t *testing.T) {
+	in := []corev1.EnvVar{{Name: "PLEX_MEDIA_SERVER_HOME", Value: "/usr/lib/plexmediaserver"}, {Name: "HTTPS_PROXY", Value: "http://old:3128"}, {Name: "TZ", Value: "UTC"}}
+	env := []corev1.EnvVar{{Name: "HTTPS_PROXY", Value: "http://proxy:3128"}, {Name: "https_proxy", Value: "http://proxy:3128"}}
+	want := []corev1.EnvVar{{Name: "PLEX_MEDIA_SERVER_HOME", Value: "/usr/lib/plexmediaserver"}, {Name: "TZ", Value: "UTC"}, {Name: "HTTPS_PROXY", Value: "http://proxy:3128"}, {Name: "https_proxy", Value: "http://proxy:3128"}}
+
+	if diff := deep.Equal(withEnv(in, env), want); diff != nil {
+		t.Errorf("withEnv() diff: %v", diff)
+	}
+	if diff := deep.Equal(withEnv(in, nil), in); diff != nil {
+		t.Errorf("withEnv() without env diff: %v", diff)
+	}
+}
+
+func Test_createJob(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	batchV1 := func(verbs ...string) []*metav1.APIResourceList {
+		return []*metav1.APIResourceList{{GroupVersion: "batch/v1", APIResources: []metav1.APIResource{
+			{Name: "jobs", Namespaced: true, Kind: "Job", Verbs: verbs},
+			{Name: "cronjobs", Namespaced: true, Kind: "CronJob", Verbs: []string{"create", "get"}},
+		}}}
+	}
+	tests := []struct {
+		name      string
+		resources []*metav1.APIResourceList
+		wantErr   error
+		created   bool
+	}{
+		{"jobs served", batchV1("create", "delete", "get", "list", "watch"), nil, true},
+		{"jobs can't be created", batchV1("get", "list"), ErrJobsUnavailable, false},
+		{"no jobs resource", []*metav1.APIResourceList{{GroupVersion: "batch/v1"}}, ErrJobsUnavailable, false},
+		{"discovery fails", nil, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cl := fake.NewSimpleClientset()
+			cl.Discovery().(*fakediscovery.FakeDiscovery).Resources = tt.resources
+			job := &batch.Job{ObjectMeta: metav1.ObjectMeta{Name: "job", Namespace: "plex"}}
+
+			_, err := createJob(ctx, cl, job)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("createJob() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			_, err = cl.BatchV1().Jobs("plex").Get(ctx, "job", metav1.GetOptions{})
+			if created := err == nil; created != tt.created {
+				t.Errorf("createJob() created job = %v, want %v", created, tt.created)
+			}
+		})
+	}
+}
+
+func Test_createJob_forbidden(t *testing.T) {
+	cl := fake.NewSimpleClientset()
+	cl.PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewForbidden(batch.Resource("jobs"), "", errors.New(`User "system:serviceaccount:plex:default" cannot create resource "jobs" in API group "batch" in the namespace "plex"`))
+	})
+	job := &batch.Job{ObjectMeta: metav1.ObjectMeta{Name: "job", Namespace: "plex"}}
+
+	_, err := createJob(context.Background(), cl, job)
+	if !errors.Is(err, ErrJobsForbidden) {
+		t.Fatalf("createJob() error = %v, want %v", err, ErrJobsForbidden)
+	}
+	for _, s := range []string{"`create`", "`jobs`", "`batch`", "namespace plex"} {
+		if !strings.Contains(err.Error(), s) {
+			t.Errorf("createJob() error = %v, want mention of %s", err, s)
+		}
+	}
+}
+
+func Test_createJob_forbiddenByPolicy(t *testing.T) {
+	for _, msg := range []string{
+		"exceeded quota: jobs, requested: count/jobs.batch=1, used: count/jobs.batch=10, limited: count/jobs.batch=10",
+		`admission webhook "policy.example.com" denied the request: privileged containers are not allowed`,
+	} {
+		cl := fake.NewSimpleClientset()
+		cl.PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, apierrors.NewForbidden(batch.Resource("jobs"), "job", errors.New(msg))
+		})
+		job := &batch.Job{ObjectMeta: metav1.ObjectMeta{Name: "job", Namespace: "plex"}}
+
+		_, err := createJob(context.Background(), cl, job)
+		if !apierrors.IsForbidden(err) {
+			t.Errorf("createJob() error = %v, want the Forbidden error", err)
+		}
+		if errors.Is(err, ErrJobsForbidden) {
+			t.Errorf("createJob() error = %v, reported as RBAC issue", err)
+		}
+	}
+}
+
+func Test_createJob_namespaceTerminating(t *testing.T) {
+	cl := fake.NewSimpleClientset()
+	cl.PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		err := apierrors.NewForbidden(batch.Resource("jobs"), "job", errors.New("unable to create new content in namespace plex because it is being terminated"))
+		err.ErrStatus.Details.Causes = []metav1.StatusCause{{Type: corev1.NamespaceTerminatingCause, Field: "plex"}}
+		return true, nil, err
+	})
+	job := &batch.Job{ObjectMeta: metav1.ObjectMeta{Name: "job", Namespace: "plex"}}
+
+	_, err := createJob(context.Background(), cl, job)
+	if !errors.Is(err, ErrNamespaceTerminating) {
+		t.Errorf("createJob() error = %v, want %v", err, ErrNamespaceTerminating)
+	}
+	if errors.Is(err, ErrJobsForbidden) {
+		t.Errorf("createJob() error = %v, terminating namespace reported as RBAC issue", err)
+	}
+}
+
+func Test_createJob_generateNameCollision(t *testing.T) {
+	tests := []struct {
+		name       string
+		job        *batch.Job
+		collisions int
+		wantErr    bool
+		creates    int
+	}{
+		{"retried once", &batch.Job{ObjectMeta: metav1.ObjectMeta{GenerateName: "pms-elastic-transcoder-", Namespace: "plex"}}, 1, false, 2},
+		{"retries exhausted", &batch.Job{ObjectMeta: metav1.ObjectMeta{GenerateName: "pms-elastic-transcoder-", Namespace: "plex"}}, 10, true, 1 + generateNameRetries},
+		{"fixed name not retried", &batch.Job{ObjectMeta: metav1.ObjectMeta{Name: "job", Namespace: "plex"}}, 1, true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cl := fake.NewSimpleClientset()
+			creates := 0
+			cl.PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				creates++
+				if creates <= tt.collisions {
+					return true, nil, apierrors.NewAlreadyExists(batch.Resource("jobs"), "pms-elastic-transcoder-abcde")
+				}
+				j := action.(k8stesting.CreateAction).GetObject().(*batch.Job).DeepCopy()
+				j.Name = j.GenerateName + "fghij"
+				return true, j, nil
+			})
+
+			j, err := createJob(context.Background(), cl, tt.job)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("createJob() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && j.Name != "pms-elastic-transcoder-fghij" {
+				t.Errorf("createJob() job name = %s, want pms-elastic-transcoder-fghij", j.Name)
+			}
+			if creates != tt.creates {
+				t.Errorf("createJob() creates = %d, want %d", creates, tt.creates)
+			}
+		})
+	}
+}
+
+// unservedDiscovery is a discovery client of an API server without any groups
+type unservedDiscovery struct {
+	*fakediscovery.FakeDiscovery
+}
+
+func (d unservedDiscovery) ServerResourcesForGroupVersion(gv string) (*metav1.APIResourceList, error) {
+	return nil, apierrors.NewNotFound(schema.GroupResource{}, gv)
+}
+
+func Test_checkJobsAPI_notServed(t *testing.T) {
+	d := unservedDiscovery{&fakediscovery.FakeDiscovery{Fake: &k8stesting.Fake{}}}
+	if err := checkJobsAPI(d); !errors.Is(err, ErrJobsUnavailable) {
+		t.Errorf("checkJobsAPI() error = %v, want %v", err, ErrJobsUnavailable)
+	}
+}
+
 func Test_waitForPodCompletion(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -71,11 +245,179 @@ func Test_waitForPodCompletion(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			cl := fake.NewSimpleClientset(tt.job)
-			if err := waitForPodCompletion(ctx, cl, tt.job); (err != nil) != tt.wantErr {
+			if err := waitForPodCompletion(ctx, cl, tt.job, 0); (err != nil) != tt.wantErr {
 				t.Errorf("waitForPodCompletion() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
 	}
+
+	t.Run("reconnects closed watch", func(t *testing.T) {
+		fc := fakeClock(t)
+		job := &batch.Job{ObjectMeta: metav1.ObjectMeta{Name: "job", Namespace: "plex"}, Status: batch.JobStatus{Active: 1}}
+		cl := fake.NewSimpleClientset(job)
+
+		fw := watch.NewFake()
+		watching := make(chan bool)
+		cl.PrependWatchReactor("jobs", func(action k8stesting.Action) (bool, watch.Interface, error) {
+			watching <- true
+			return true, fw, nil
+		})
+
+		done := make(chan error)
+		go func() { done <- waitForPodCompletion(ctx, cl, job, time.Minute) }()
+
+		// Job completes while the watch is disconnected
+		<-watching
+		job.Status = batch.JobStatus{Succeeded: 1}
+		cl.Tracker().Update(batch.SchemeGroupVersion.WithResource("jobs"), job, job.Namespace)
+		fw.Stop()
+
+		if err := stepUntilDone(t, fc, watchBackoffMax, done); err != nil {
+			t.Errorf("waitForPodCompletion() error = %v, want nil", err)
+		}
+	})
+
+	t.Run("backs off repeated closes", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		fc := fakeClock(t)
+		job := &batch.Job{ObjectMeta: metav1.ObjectMeta{Name: "job", Namespace: "plex"}, Status: batch.JobStatus{Active: 1}}
+		cl := fake.NewSimpleClientset(job)
+
+		watches := make(chan *watch.FakeWatcher, 1)
+		cl.PrependWatchReactor("jobs", func(action k8stesting.Action) (bool, watch.Interface, error) {
+			fw := watch.NewFake()
+			watches <- fw
+			return true, fw, nil
+		})
+
+		done := make(chan error)
+		go func() { done <- waitForPodCompletion(ctx, cl, job, time.Minute) }()
+
+		// Each close waits at least the current backoff, at most the backoff
+		// plus jitter, before watching again
+		fw := <-watches
+		for _, d := range []time.Duration{watchBackoffInitial, 2 * watchBackoffInitial} {
+			fw.Stop()
+			waitForTimer(t, fc)
+			fc.Step(d - time.Nanosecond)
+			select {
+			case <-watches:
+				t.Fatalf("watch reconnected before %v", d)
+			case <-time.After(50 * time.Millisecond):
+			}
+			fc.Step(time.Duration(float64(d) * watchBackoffJitter))
+			select {
+			case fw = <-watches:
+			case <-time.After(5 * time.Second):
+				t.Fatalf("watch not reconnected after %v", d)
+			}
+		}
+
+		cancel()
+		if err := <-done; err == nil {
+			t.Errorf("waitForPodCompletion() error = nil, want context cancelled")
+		}
+	})
+}
+
+func Test_parseWaitStrategy(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy string
+		interval string
+		want     time.Duration
+		wantErr  bool
+	}{
+		{"default", "", "", 0, false},
+		{"watch", "watch", "", 0, false},
+		{"watch with interval", "watch", "1s", 0, true},
+		{"poll", "poll", "", defaultPollInterval, false},
+		{"poll with interval", "poll", "30s", 30 * time.Second, false},
+		{"invalid interval", "poll", "0s", 0, true},
+		{"invalid strategy", "push", "", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseWaitStrategy(tt.strategy, tt.interval)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseWaitStrategy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseWaitStrategy() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_pollForPodCompletion(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tests := []struct {
+		name    string
+		job     *batch.Job
+		wantErr bool
+	}{
+		{"successful run", &batch.Job{ObjectMeta: metav1.ObjectMeta{Name: "job", Namespace: "plex"}, Status: batch.JobStatus{Succeeded: 1}}, false},
+		{"failed job", &batch.Job{ObjectMeta: metav1.ObjectMeta{Name: "job", Namespace: "plex"}, Status: batch.JobStatus{Failed: 1}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cl := fake.NewSimpleClientset(tt.job)
+			if err := pollForPodCompletion(ctx, cl, tt.job, time.Millisecond); (err != nil) != tt.wantErr {
+				t.Errorf("pollForPodCompletion() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	t.Run("polls until complete", func(t *testing.T) {
+		job := &batch.Job{ObjectMeta: metav1.ObjectMeta{Name: "job", Namespace: "plex"}, Status: batch.JobStatus{Active: 1}}
+		cl := fake.NewSimpleClientset(job)
+
+		polls := 0
+		cl.PrependReactor("get", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			polls++
+			if polls == 3 {
+				job.Status = batch.JobStatus{Succeeded: 1}
+			}
+			return true, job.DeepCopy(), nil
+		})
+
+		fc := fakeClock(t)
+		errCh := make(chan error, 1)
+		go func() { errCh <- pollForPodCompletion(ctx, cl, job, time.Minute) }()
+		for i := 0; i < 2; i++ {
+			waitForTimer(t, fc)
+			fc.Step(time.Minute)
+		}
+		if err := <-errCh; err != nil {
+			t.Errorf("pollForPodCompletion() error = %v, want nil", err)
+		}
+		if polls != 3 {
+			t.Errorf("job fetched %d times, want 3", polls)
+		}
+	})
+}
+
+func Test_jobWatchOptions(t *testing.T) {
+	job := &batch.Job{ObjectMeta: metav1.ObjectMeta{Name: "pms-elastic-transcoder-abcde", Namespace: "plex", ResourceVersion: "42"}}
+	timeout := int64(300)
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		want    metav1.ListOptions
+	}{
+		{"no timeout", 0, metav1.ListOptions{FieldSelector: "metadata.name=pms-elastic-transcoder-abcde", ResourceVersion: "42"}},
+		{"timeout", 5 * time.Minute, metav1.ListOptions{FieldSelector: "metadata.name=pms-elastic-transcoder-abcde", ResourceVersion: "42", TimeoutSeconds: &timeout}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if diff := deep.Equal(jobWatchOptions(job, tt.timeout), tt.want); diff != nil {
+				t.Errorf("jobWatchOptions() diff: %v", diff)
+			}
+		})
+	}
 }
 
 func Test_jobDone(t *testing.T) {
@@ -164,6 +506,14 @@ func Test_podWatcher(t *testing.T) {
 		<-done
 	})
 
+	t.Run("closed watch", func(t *testing.T) {
+		fw := watch.NewFake()
+		fw.Stop()
+		if err := podWatcher(ctx, fw); err != errWatchClosed {
+			t.Errorf("podWatcher() error = %v, want %v", err, errWatchClosed)
+		}
+	})
+
 	t.Run("termination from context", func(t *testing.T) {
 		ctx, cancel := context.WithCancel(ctx)
 		defer cancel()
@@ -266,3 +616,501 @@ func Test_generateJob(t *testing.T) {
 		t.Errorf("generateJob() output differs, diff: %v", diff)
 	}
 }
+
+func Test_generateJob_options(t *testing.T) {
+	base := PmsMetadata{Name: "pms", Namespace: "plex", UID: "abc123", PmsImage: "pms:latest", PmsAddr: "kubeplex:32400", KubePlexImage: "kubeplex:latest"}
+	tests := []struct {
+		name   string
+		modify func(m *PmsMetadata)
+		check  func(t *testing.T, job *batch.Job)
+	}{
+		{"host network", func(m *PmsMetadata) { m.HostNetwork = true }, func(t *testing.T, job *batch.Job) {
+			spec := job.Spec.Template.Spec
+			if !spec.HostNetwork {
+				t.Errorf("HostNetwork = false, want true")
+			}
+			if spec.DNSPolicy != corev1.DNSClusterFirstWithHostNet {
+				t.Errorf("DNSPolicy = %v, want %v", spec.DNSPolicy, corev1.DNSClusterFirstWithHostNet)
+			}
+			// Two transcodes, or a transcode and PMS, on the host network of
+			// the same node are kept apart by the scheduler through the host
+			// port of the launcher
+			ports := spec.Containers[0].Ports
+			if len(ports) != 1 || ports[0].HostPort != 32400 || ports[0].ContainerPort != 32400 {
+				t.Errorf("Ports = %v, want host port 32400", ports)
+			}
+		}},
+		{"no host port without host network", func(m *PmsMetadata) {}, func(t *testing.T, job *batch.Job) {
+			if ports := job.Spec.Template.Spec.Containers[0].Ports; len(ports) != 0 {
+				t.Errorf("Ports = %v, want none", ports)
+			}
+		}},
+		{"share process namespace default", func(m *PmsMetadata) {}, func(t *testing.T, job *batch.Job) {
+			if sp := job.Spec.Template.Spec.ShareProcessNamespace; sp != nil {
+				t.Errorf("ShareProcessNamespace = %v, want nil", *sp)
+			}
+		}},
+		{"share process namespace", func(m *PmsMetadata) { sp := true; m.SharePID = &sp }, func(t *testing.T, job *batch.Job) {
+			if sp := job.Spec.Template.Spec.ShareProcessNamespace; sp == nil || !*sp {
+				t.Errorf("ShareProcessNamespace = %v, want true", sp)
+			}
+		}},
+		{"service links default", func(m *PmsMetadata) {}, func(t *testing.T, job *batch.Job) {
+			if sl := job.Spec.Template.Spec.EnableServiceLinks; sl != nil {
+				t.Errorf("EnableServiceLinks = %v, want nil", *sl)
+			}
+		}},
+		{"service links disabled", func(m *PmsMetadata) { m.ServiceLinks = new(bool) }, func(t *testing.T, job *batch.Job) {
+			if sl := job.Spec.Template.Spec.EnableServiceLinks; sl == nil || *sl {
+				t.Errorf("EnableServiceLinks = %v, want false", sl)
+			}
+		}},
+		{"service links enabled", func(m *PmsMetadata) { sl := true; m.ServiceLinks = &sl }, func(t *testing.T, job *batch.Job) {
+			if sl := job.Spec.Template.Spec.EnableServiceLinks; sl == nil || !*sl {
+				t.Errorf("EnableServiceLinks = %v, want true", sl)
+			}
+		}},
+		{"arch", func(m *PmsMetadata) { arch := "arm64"; m.Arch = &arch }, func(t *testing.T, job *batch.Job) {
+			if diff := deep.Equal(job.Spec.Template.Spec.NodeSelector, map[string]string{"kubernetes.io/arch": "arm64"}); diff != nil {
+				t.Errorf("NodeSelector diff: %v", diff)
+			}
+		}},
+		{"pod template", func(m *PmsMetadata) {
+			m.PodTemplate = []byte(`{
+				"priorityClassName": "transcode",
+				"restartPolicy": "Always",
+				"tolerations": [{"key": "transcode", "operator": "Exists"}],
+				"containers": [{"name": "plex", "command": ["sh"], "env": [{"name": "EXTRA", "value": "1"}], "volumeMounts": [{"name": "other", "mountPath": "/shared"}]}],
+				"initContainers": [{"name": "kube-plex-init", "image": "busybox"}],
+				"volumes": [{"name": "shared", "hostPath": {"path": "/tmp"}}, {"name": "other", "emptyDir": {}}]
+			}`)
+		}, func(t *testing.T, job *batch.Job) {
+			spec := job.Spec.Template.Spec
+			if spec.PriorityClassName != "transcode" || len(spec.Tolerations) != 1 {
+				t.Errorf("PriorityClassName = %q, Tolerations = %v, want merged from template", spec.PriorityClassName, spec.Tolerations)
+			}
+			if spec.RestartPolicy != corev1.RestartPolicyNever {
+				t.Errorf("RestartPolicy = %v, want Never", spec.RestartPolicy)
+			}
+			c := spec.Containers[0]
+			if c.Command[0] != "/shared/transcode-launcher" || c.Image != "pms:latest" {
+				t.Errorf("transcode container command = %v, image = %s, want launcher and pms:latest", c.Command, c.Image)
+			}
+			if e := c.Env[len(c.Env)-1]; e.Name != "EXTRA" {
+				t.Errorf("last env var = %v, want EXTRA from template", e)
+			}
+			if diff := deep.Equal(c.VolumeMounts, []corev1.VolumeMount{{Name: "shared", MountPath: "/shared"}}); diff != nil {
+				t.Errorf("VolumeMounts diff: %v", diff)
+			}
+			if spec.InitContainers[0].Image != "kubeplex:latest" {
+				t.Errorf("init container image = %s, want kubeplex:latest", spec.InitContainers[0].Image)
+			}
+			if len(spec.Volumes) != 2 || spec.Volumes[0].EmptyDir == nil || spec.Volumes[1].Name != "other" {
+				t.Errorf("Volumes = %v, want emptyDir shared volume and other", spec.Volumes)
+			}
+		}},
+		{"node selector", func(m *PmsMetadata) { m.NodeSelector = map[string]string{"pool": "transcode"} }, func(t *testing.T, job *batch.Job) {
+			if diff := deep.Equal(job.Spec.Template.Spec.NodeSelector, map[string]string{"kubernetes.io/arch": "amd64", "pool": "transcode"}); diff != nil {
+				t.Errorf("NodeSelector diff: %v", diff)
+			}
+		}},
+		{"any arch", func(m *PmsMetadata) { m.Arch = new(string) }, func(t *testing.T, job *batch.Job) {
+			if ns := job.Spec.Template.Spec.NodeSelector; ns != nil {
+				t.Errorf("NodeSelector = %v, want nil", ns)
+			}
+		}},
+		{"devices", func(m *PmsMetadata) {
+			m.Devices = []string{"/dev/dri"}
+			m.Sidecar = &corev1.Container{Name: "kube-plex-sidecar", Image: "uploader:v1"}
+		}, func(t *testing.T, job *batch.Job) {
+			spec := job.Spec.Template.Spec
+			v := spec.Volumes[len(spec.Volumes)-1]
+			if v.Name != "kube-plex-device-0" || v.HostPath == nil || v.HostPath.Path != "/dev/dri" {
+				t.Errorf("last volume = %v, want host path /dev/dri", v)
+			}
+			vm := spec.Containers[0].VolumeMounts
+			if last := vm[len(vm)-1]; last.Name != "kube-plex-device-0" || last.MountPath != "/dev/dri" {
+				t.Errorf("last transcode mount = %v, want /dev/dri", last)
+			}
+			for _, m := range spec.Containers[1].VolumeMounts {
+				if m.Name == "kube-plex-device-0" {
+					t.Errorf("device mounted in sidecar")
+				}
+			}
+		}},
+		{"output pvc", func(m *PmsMetadata) {
+			m.OutputPVC, m.OutputPath = "transcodes", "/output"
+			m.Sidecar = &corev1.Container{Name: "kube-plex-sidecar", Image: "uploader:v1"}
+		}, func(t *testing.T, job *batch.Job) {
+			spec := job.Spec.Template.Spec
+			found := false
+			for _, v := range spec.Volumes {
+				if v.Name == "kube-plex-output" {
+					found = v.PersistentVolumeClaim != nil && v.PersistentVolumeClaim.ClaimName == "transcodes"
+				}
+			}
+			if !found {
+				t.Errorf("Volumes = %v, want claim transcodes", spec.Volumes)
+			}
+			for _, c := range spec.Containers[:2] {
+				mounted := false
+				for _, vm := range c.VolumeMounts {
+					mounted = mounted || (vm.Name == "kube-plex-output" && vm.MountPath == "/output")
+				}
+				if !mounted {
+					t.Errorf("container %s VolumeMounts = %v, want /output", c.Name, c.VolumeMounts)
+				}
+			}
+		}},
+		{"transcode lifecycle", func(m *PmsMetadata) {
+			m.Lifecycle = &corev1.Lifecycle{PreStop: &corev1.Handler{Exec: &corev1.ExecAction{Command: []string{"sleep", "5"}}}}
+			m.Sidecar = &corev1.Container{Name: "kube-plex-sidecar", Image: "uploader:v1"}
+		}, func(t *testing.T, job *batch.Job) {
+			c := job.Spec.Template.Spec.Containers
+			if lc := c[0].Lifecycle; lc == nil || lc.PreStop == nil || lc.PreStop.Exec == nil || lc.PreStop.Exec.Command[0] != "sleep" {
+				t.Errorf("transcode container Lifecycle = %v, want preStop sleep", lc)
+			}
+			if c[1].Lifecycle != nil {
+				t.Errorf("sidecar Lifecycle = %v, want nil", c[1].Lifecycle)
+			}
+		}},
+		{"transcode use init image", func(m *PmsMetadata) { m.UseInitImage = true }, func(t *testing.T, job *batch.Job) {
+			if img := job.Spec.Template.Spec.Containers[0].Image; img != "kubeplex:latest" {
+				t.Errorf("transcode container Image = %s, want kubeplex:latest", img)
+			}
+		}},
+		{"backup exclusion label", func(m *PmsMetadata) { m.PodLabels = defaultBackupLabels }, func(t *testing.T, job *batch.Job) {
+			if v := job.Spec.Template.Labels["velero.io/exclude-from-backup"]; v != "true" {
+				t.Errorf("pod label velero.io/exclude-from-backup = %q, want true", v)
+			}
+		}},
+		{"hostname and subdomain", func(m *PmsMetadata) { m.Hostname, m.Subdomain = "transcoder", "transcodes" }, func(t *testing.T, job *batch.Job) {
+			if spec := job.Spec.Template.Spec; spec.Hostname != "transcoder" || spec.Subdomain != "transcodes" {
+				t.Errorf("Hostname, Subdomain = %s, %s, want transcoder, transcodes", spec.Hostname, spec.Subdomain)
+			}
+		}},
+		{"debug stdin and tty", func(m *PmsMetadata) {
+			m.DebugStdin, m.DebugTTY = true, true
+			m.Sidecar = &corev1.Container{Name: "kube-plex-sidecar", Image: "uploader:v1"}
+		}, func(t *testing.T, job *batch.Job) {
+			c := job.Spec.Template.Spec.Containers
+			if !c[0].Stdin || !c[0].TTY {
+				t.Errorf("transcode container Stdin, TTY = %v, %v, want true", c[0].Stdin, c[0].TTY)
+			}
+			if c[1].Stdin || c[1].TTY {
+				t.Errorf("sidecar Stdin, TTY = %v, %v, want false", c[1].Stdin, c[1].TTY)
+			}
+		}},
+		{"termination message default", func(m *PmsMetadata) {}, func(t *testing.T, job *batch.Job) {
+			c := job.Spec.Template.Spec.Containers[0]
+			if c.TerminationMessagePolicy != "" || c.TerminationMessagePath != "" {
+				t.Errorf("termination message policy, path = %q, %q, want unset", c.TerminationMessagePolicy, c.TerminationMessagePath)
+			}
+		}},
+		{"termination message", func(m *PmsMetadata) {
+			m.TermMsgPolicy = corev1.TerminationMessageFallbackToLogsOnError
+			m.TermMsgPath = "/shared/termination-log"
+			m.Sidecar = &corev1.Container{Name: "sidecar"}
+		}, func(t *testing.T, job *batch.Job) {
+			c := job.Spec.Template.Spec.Containers
+			if c[0].TerminationMessagePolicy != corev1.TerminationMessageFallbackToLogsOnError || c[0].TerminationMessagePath != "/shared/termination-log" {
+				t.Errorf("termination message policy, path = %q, %q, want FallbackToLogsOnError, /shared/termination-log", c[0].TerminationMessagePolicy, c[0].TerminationMessagePath)
+			}
+			if c[1].TerminationMessagePolicy != "" {
+				t.Errorf("sidecar termination message policy = %q, want unset", c[1].TerminationMessagePolicy)
+			}
+		}},
+		{"avoid pms node", func(m *PmsMetadata) { m.AvoidNode = "node1" }, func(t *testing.T, job *batch.Job) {
+			want := &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{PreferredDuringSchedulingIgnoredDuringExecution: []corev1.PreferredSchedulingTerm{{
+				Weight:     100,
+				Preference: corev1.NodeSelectorTerm{MatchFields: []corev1.NodeSelectorRequirement{{Key: "metadata.name", Operator: corev1.NodeSelectorOpNotIn, Values: []string{"node1"}}}},
+			}}}}
+			if diff := deep.Equal(job.Spec.Template.Spec.Affinity, want); diff != nil {
+				t.Errorf("Affinity diff: %v", diff)
+			}
+		}},
+		{"avoid pms node with pod template affinity", func(m *PmsMetadata) {
+			m.AvoidNode = "node1"
+			m.PodTemplate = []byte(`{"affinity": {
+				"nodeAffinity": {"preferredDuringSchedulingIgnoredDuringExecution": [{"weight": 10, "preference": {"matchExpressions": [{"key": "gpu", "operator": "Exists"}]}}]},
+				"podAntiAffinity": {"preferredDuringSchedulingIgnoredDuringExecution": [{"weight": 50, "podAffinityTerm": {"topologyKey": "kubernetes.io/hostname"}}]}
+			}}`)
+		}, func(t *testing.T, job *batch.Job) {
+			a := job.Spec.Template.Spec.Affinity
+			if a == nil || a.PodAntiAffinity == nil || len(a.PodAntiAffinity.PreferredDuringSchedulingIgnoredDuringExecution) != 1 {
+				t.Fatalf("Affinity = %v, want pod anti-affinity from template", a)
+			}
+			terms := a.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution
+			if len(terms) != 2 || terms[0].Weight != 10 || terms[1].Preference.MatchFields[0].Values[0] != "node1" {
+				t.Errorf("preferred node affinity = %v, want template term and PMS node anti-affinity", terms)
+			}
+		}},
+		{"preemption policy", func(m *PmsMetadata) { p := corev1.PreemptNever; m.Preemption = &p }, func(t *testing.T, job *batch.Job) {
+			if p := job.Spec.Template.Spec.PreemptionPolicy; p == nil || *p != corev1.PreemptNever {
+				t.Errorf("PreemptionPolicy = %v, want Never", p)
+			}
+		}},
+		{"data locality", func(m *PmsMetadata) {
+			m.Locality = []corev1.NodeSelectorTerm{{MatchFields: []corev1.NodeSelectorRequirement{{Key: "metadata.name", Operator: corev1.NodeSelectorOpIn, Values: []string{"node1"}}}}}
+		}, func(t *testing.T, job *batch.Job) {
+			a := job.Spec.Template.Spec.Affinity
+			if a == nil || a.NodeAffinity == nil || len(a.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution) != 1 {
+				t.Fatalf("Affinity = %+v, want one preferred node term", a)
+			}
+			if p := a.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution[0]; p.Weight != 100 || p.Preference.MatchFields[0].Values[0] != "node1" {
+				t.Errorf("preferred term = %+v, want node1 with weight 100", p)
+			}
+		}},
+		{"proxy env", func(m *PmsMetadata) { m.ProxyEnv = []corev1.EnvVar{{Name: "HTTP_PROXY", Value: "http://proxy:3128"}} }, func(t *testing.T, job *batch.Job) {
+			env := job.Spec.Template.Spec.Containers[0].Env
+			if len(env) == 0 || env[len(env)-1] != (corev1.EnvVar{Name: "HTTP_PROXY", Value: "http://proxy:3128"}) {
+				t.Errorf("transcode container env = %v, want HTTP_PROXY", env)
+			}
+		}},
+		{"token expiration", func(m *PmsMetadata) {
+			m.TokenExpiry = 900
+			m.PodTemplate = []byte(`{"volumes": [{"name": "token", "projected": {"sources": [
+				{"serviceAccountToken": {"path": "token", "audience": "vault"}},
+				{"configMap": {"name": "ca"}}
+			]}}]}`)
+		}, func(t *testing.T, job *batch.Job) {
+			for _, v := range job.Spec.Template.Spec.Volumes {
+				if v.Name != "token" {
+					continue
+				}
+				if e := v.Projected.Sources[0].ServiceAccountToken.ExpirationSeconds; e == nil || *e != 900 {
+					t.Errorf("ExpirationSeconds = %v, want 900", e)
+				}
+				return
+			}
+			t.Errorf("token volume missing from %v", job.Spec.Template.Spec.Volumes)
+		}},
+		{"runtime class", func(m *PmsMetadata) { rc := "gvisor"; m.RuntimeClass = &rc }, func(t *testing.T, job *batch.Job) {
+			if rc := job.Spec.Template.Spec.RuntimeClassName; rc == nil || *rc != "gvisor" {
+				t.Errorf("RuntimeClassName = %v, want gvisor", rc)
+			}
+		}},
+		{"scratch ephemeral claim", func(m *PmsMetadata) {
+			v, err := parseEphemeralVolume("nvme:20Gi")
+			if err != nil {
+				t.Fatal(err)
+			}
+			m.ScratchVolume = &corev1.VolumeSource{Ephemeral: v}
+		}, func(t *testing.T, job *batch.Job) {
+			for _, v := range job.Spec.Template.Spec.Volumes {
+				if v.Name != "shared" {
+					continue
+				}
+				if v.Ephemeral == nil || v.EmptyDir != nil {
+					t.Fatalf("shared volume = %+v, want ephemeral volume", v.VolumeSource)
+				}
+				spec := v.Ephemeral.VolumeClaimTemplate.Spec
+				if sc := spec.StorageClassName; sc == nil || *sc != "nvme" {
+					t.Errorf("StorageClassName = %v, want nvme", sc)
+				}
+				if size := spec.Resources.Requests[corev1.ResourceStorage]; size.String() != "20Gi" {
+					t.Errorf("storage request = %s, want 20Gi", size.String())
+				}
+			}
+		}},
+		{"scratch empty dir default", func(m *PmsMetadata) {}, func(t *testing.T, job *batch.Job) {
+			for _, v := range job.Spec.Template.Spec.Volumes {
+				if v.Name == "shared" && v.EmptyDir == nil {
+					t.Errorf("shared volume = %+v, want emptyDir", v.VolumeSource)
+				}
+			}
+		}},
+		{"multus networks", func(m *PmsMetadata) {
+			m.PodAnnotations = map[string]string{multusNetworksAnnotation: "gpu-net@eth1"}
+		}, func(t *testing.T, job *batch.Job) {
+			if nw := job.Spec.Template.Annotations["k8s.v1.cni.cncf.io/networks"]; nw != "gpu-net@eth1" {
+				t.Errorf("networks annotation = %q, want gpu-net@eth1", nw)
+			}
+		}},
+		{"gpu product", func(m *PmsMetadata) {
+			m.ExtraResources = corev1.ResourceList{"nvidia.com/gpu": resource.MustParse("1")}
+			m.NodeSelector = map[string]string{gpuProductLabel: "NVIDIA-A10"}
+		}, func(t *testing.T, job *batch.Job) {
+			spec := job.Spec.Template.Spec
+			if p := spec.NodeSelector["nvidia.com/gpu.product"]; p != "NVIDIA-A10" {
+				t.Errorf("NodeSelector = %v, want nvidia.com/gpu.product=NVIDIA-A10", spec.NodeSelector)
+			}
+			if _, ok := spec.Containers[0].Resources.Limits["nvidia.com/gpu"]; !ok {
+				t.Errorf("Limits = %v, want nvidia.com/gpu", spec.Containers[0].Resources.Limits)
+			}
+		}},
+		{"render group", func(m *PmsMetadata) { gid := int64(109); m.RenderGroup = &gid }, func(t *testing.T, job *batch.Job) {
+			sc := job.Spec.Template.Spec.SecurityContext
+			if sc == nil || len(sc.SupplementalGroups) != 1 || sc.SupplementalGroups[0] != 109 {
+				t.Errorf("SecurityContext = %v, want supplemental group 109", sc)
+			}
+		}},
+		{"sysctls", func(m *PmsMetadata) {
+			gid := int64(109)
+			m.RenderGroup = &gid
+			m.Sysctls = []corev1.Sysctl{{Name: "net.core.rmem_max", Value: "4194304"}}
+		}, func(t *testing.T, job *batch.Job) {
+			sc := job.Spec.Template.Spec.SecurityContext
+			want := []corev1.Sysctl{{Name: "net.core.rmem_max", Value: "4194304"}}
+			if sc == nil || !reflect.DeepEqual(sc.Sysctls, want) || len(sc.SupplementalGroups) != 1 {
+				t.Errorf("SecurityContext = %v, want sysctls %v and supplemental group", sc, want)
+			}
+		}},
+		{"no owner reference", func(m *PmsMetadata) { m.NoOwnerReference = true }, func(t *testing.T, job *batch.Job) {
+			if refs := job.OwnerReferences; refs != nil {
+				t.Errorf("OwnerReferences = %v, want none", refs)
+			}
+		}},
+		{"static labels", func(m *PmsMetadata) { m.PodLabels = map[string]string{"team": "media", "app": "plex"} }, func(t *testing.T, job *batch.Job) {
+			if l := job.Spec.Template.Labels; l["team"] != "media" || l["app"] != "plex" {
+				t.Errorf("pod labels = %v, want team and app labels", l)
+			}
+		}},
+		{"pdb label", func(m *PmsMetadata) { m.PodLabels = map[string]string{"pdb.example.com/transcode": "plex"} }, func(t *testing.T, job *batch.Job) {
+			if v := job.Spec.Template.Labels["pdb.example.com/transcode"]; v != "plex" {
+				t.Errorf("pod label pdb.example.com/transcode = %q, want plex", v)
+			}
+		}},
+		{"pod overhead", func(m *PmsMetadata) { m.PodOverhead = corev1.ResourceList{"cpu": resource.MustParse("250m")} }, func(t *testing.T, job *batch.Job) {
+			if diff := deep.Equal(job.Spec.Template.Spec.Overhead, corev1.ResourceList{"cpu": resource.MustParse("250m")}); diff != nil {
+				t.Errorf("Overhead diff: %v", diff)
+			}
+		}},
+		{"extra containers", func(m *PmsMetadata) { m.ExtraContainers = []corev1.Container{{Name: "encoder", Image: "encoder:v1"}} }, func(t *testing.T, job *batch.Job) {
+			spec := job.Spec.Template.Spec
+			if len(spec.Containers) != 2 {
+				t.Fatalf("got %d containers, want 2", len(spec.Containers))
+			}
+			if spec.Containers[0].Name != "plex" || spec.Containers[1].Name != "encoder" {
+				t.Errorf("container names = %s, %s, want plex, encoder", spec.Containers[0].Name, spec.Containers[1].Name)
+			}
+		}},
+		{"sidecar", func(m *PmsMetadata) { m.Sidecar = &corev1.Container{Name: "kube-plex-sidecar", Image: "uploader:v1"} }, func(t *testing.T, job *batch.Job) {
+			c := job.Spec.Template.Spec.Containers
+			if len(c) != 2 {
+				t.Fatalf("got %d containers, want 2", len(c))
+			}
+			if diff := deep.Equal(c[1].VolumeMounts, c[0].VolumeMounts); diff != nil {
+				t.Errorf("sidecar mounts differ from transcoder: %v", diff)
+			}
+			if c[1].WorkingDir != "/" {
+				t.Errorf("sidecar WorkingDir = %s, want /", c[1].WorkingDir)
+			}
+			if want := []corev1.EnvVar{{Name: "KUBE_PLEX_DONE_FILE", Value: "/shared/transcode-done"}}; !reflect.DeepEqual(c[1].Env, want) {
+				t.Errorf("sidecar Env = %v, want %v", c[1].Env, want)
+			}
+		}},
+		{"sidecar with completion file", func(m *PmsMetadata) {
+			m.Sidecar = &corev1.Container{Name: "kube-plex-sidecar", Image: "uploader:v1"}
+			m.CompletionFile = "/shared/status/done"
+		}, func(t *testing.T, job *batch.Job) {
+			c := job.Spec.Template.Spec.Containers
+			if want := []corev1.EnvVar{{Name: "KUBE_PLEX_DONE_FILE", Value: "/shared/status/done"}}; !reflect.DeepEqual(c[1].Env, want) {
+				t.Errorf("sidecar Env = %v, want %v", c[1].Env, want)
+			}
+		}},
+		{"pod labels and annotations", func(m *PmsMetadata) {
+			m.PodLabels = map[string]string{"a": "b"}
+			m.PodAnnotations = map[string]string{"c": "d"}
+		}, func(t *testing.T, job *batch.Job) {
+			meta := job.Spec.Template.ObjectMeta
+			if !reflect.DeepEqual(meta.Labels, map[string]string{"a": "b"}) {
+				t.Errorf("pod labels = %v, want a=b", meta.Labels)
+			}
+			if !reflect.DeepEqual(meta.Annotations, map[string]string{"c": "d"}) {
+				t.Errorf("pod annotations = %v, want c=d", meta.Annotations)
+			}
+		}},
+		{"scratch volume", func(m *PmsMetadata) {
+			m.ScratchVolume = &corev1.VolumeSource{CSI: &corev1.CSIVolumeSource{Driver: "local.csi.example.com"}}
+		}, func(t *testing.T, job *batch.Job) {
+			v := job.Spec.Template.Spec.Volumes[0]
+			if v.Name != "shared" || v.CSI == nil || v.EmptyDir != nil {
+				t.Errorf("shared volume = %v, want CSI volume", v)
+			}
+		}},
+		{"security context", func(m *PmsMetadata) {
+			uid := int64(1000)
+			m.SecurityContext = &corev1.SecurityContext{RunAsUser: &uid}
+		}, func(t *testing.T, job *batch.Job) {
+			sc := job.Spec.Template.Spec.Containers[0].SecurityContext
+			if sc == nil || sc.RunAsUser == nil || *sc.RunAsUser != 1000 {
+				t.Errorf("transcode container security context = %v, want RunAsUser 1000", sc)
+			}
+		}},
+		{"maximum lifetime", func(m *PmsMetadata) { m.MaxLifetime = time.Hour }, func(t *testing.T, job *batch.Job) {
+			if d := job.Spec.ActiveDeadlineSeconds; d == nil || *d != 3600 {
+				t.Errorf("ActiveDeadlineSeconds = %v, want 3600", d)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := base
+			tt.modify(&m)
+			job, err := generateJob("/", m, nil, []string{"a"})
+			if err != nil {
+				t.Fatalf("generateJob() returned error, err=%v", err)
+			}
+			tt.check(t, job)
+		})
+	}
+}
+
+func Test_generateJob_sharedVolume(t *testing.T) {
+	base := PmsMetadata{Name: "pms", Namespace: "plex", UID: "abc123", PmsImage: "pms:latest", PmsAddr: "kubeplex:32400", KubePlexImage: "kubeplex:latest"}
+	tests := []struct {
+		name   string
+		modify func(m *PmsMetadata)
+	}{
+		{"pms volume named shared", func(m *PmsMetadata) {
+			m.Volumes = []corev1.Volume{{Name: "shared", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}}}
+			m.VolumeMounts = []corev1.VolumeMount{{Name: "shared", MountPath: "/data"}}
+		}},
+		{"pms volume mounted at /shared", func(m *PmsMetadata) {
+			m.Volumes = []corev1.Volume{{Name: "config", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}}}
+			m.VolumeMounts = []corev1.VolumeMount{{Name: "config", MountPath: "/shared/"}}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := base
+			tt.modify(&m)
+			if _, err := generateJob("/", m, nil, []string{"a"}); !errors.Is(err, ErrSharedVolume) {
+				t.Errorf("generateJob() error = %v, want %v", err, ErrSharedVolume)
+			}
+		})
+	}
+}
+
+func Test_validateSharedVolume(t *testing.T) {
+	shared := corev1.VolumeMount{Name: "shared", MountPath: "/shared"}
+	spec := func(initMounts, mounts []corev1.VolumeMount) corev1.PodSpec {
+		return corev1.PodSpec{
+			Volumes:        []corev1.Volume{{Name: "shared"}},
+			InitContainers: []corev1.Container{{Name: "kube-plex-init", VolumeMounts: initMounts}},
+			Containers:     []corev1.Container{{Name: "plex", VolumeMounts: mounts}},
+		}
+	}
+	tests := []struct {
+		name    string
+		spec    corev1.PodSpec
+		wantErr error
+	}{
+		{"shared", spec([]corev1.VolumeMount{shared}, []corev1.VolumeMount{shared, {Name: "data", MountPath: "/data"}}), nil},
+		{"missing in init container", spec(nil, []corev1.VolumeMount{shared}), ErrSharedVolume},
+		{"missing in transcode container", spec([]corev1.VolumeMount{shared}, []corev1.VolumeMount{{Name: "data", MountPath: "/data"}}), ErrSharedVolume},
+		{"other path", spec([]corev1.VolumeMount{shared}, []corev1.VolumeMount{{Name: "shared", MountPath: "/launcher"}}), ErrSharedVolume},
+		{"read only", spec([]corev1.VolumeMount{{Name: "shared", MountPath: "/shared", ReadOnly: true}}, []corev1.VolumeMount{shared}), ErrSharedVolume},
+		{"sub path", spec([]corev1.VolumeMount{shared}, []corev1.VolumeMount{{Name: "shared", MountPath: "/shared", SubPath: "bin"}}), ErrSharedVolume},
+		{"missing volume", corev1.PodSpec{InitContainers: spec(nil, nil).InitContainers, Containers: spec(nil, nil).Containers}, ErrSharedVolume},
+		{"missing transcode container", corev1.PodSpec{Volumes: []corev1.Volume{{Name: "shared"}}, InitContainers: []corev1.Container{{Name: "kube-plex-init", VolumeMounts: []corev1.VolumeMount{shared}}}}, ErrContainerMissing},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateSharedVolume(tt.spec, "plex"); !errors.Is(err, tt.wantErr) {
+				t.Errorf("validateSharedVolume() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -2,20 +2,24 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"os"
 	"os/exec"
 	"os/signal"
-	"path/filepath"
 	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/munnerz/kube-plex/internal/ffmpeg"
 	"github.com/munnerz/kube-plex/internal/logger"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+	batch "k8s.io/api/batch/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/klog/v2"
 )
 
@@ -54,17 +58,13 @@ func main() {
 		klog.Infof("Codec server listening on port %d", codecPort)
 	}
 
-	cfg, err := rest.InClusterConfig()
+	cfg, err := restConfig()
 	if err != nil {
-		// fallback to local config for development
-		kubeconfig := filepath.Join("~", ".kube", "config")
-		if ke := os.Getenv("KUBECONFIG"); len(ke) > 0 {
-			kubeconfig = ke
-		}
-		cfg, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
-		if err != nil {
-			klog.Exitf("Error building kubeconfig: %s", err)
-		}
+		klog.Exitf("Error building kubeconfig: %s", err)
+	}
+
+	if pb, _ := strconv.ParseBool(os.Getenv("KUBE_PLEX_API_PROTOBUF")); pb {
+		cfg = protobufConfig(cfg)
 	}
 
 	kubeClient, err := kubernetes.NewForConfig(cfg)
@@ -72,12 +72,133 @@ func main() {
 		klog.Exitf("Error building Kubernetes clientset: %s", err)
 	}
 
-	podName := os.Getenv("POD_NAME")
-	podNamespace := os.Getenv("POD_NAMESPACE")
+	// Optionally create transcode jobs (and fetch metadata) as another identity
+	imp, err := parseImpersonation(os.Getenv("KUBE_PLEX_IMPERSONATE_USER"), os.Getenv("KUBE_PLEX_IMPERSONATE_GROUPS"))
+	if err != nil {
+		klog.Exitf("Invalid impersonation configuration: %v", err)
+	}
+	metaClient := kubeClient
+	if imp.UserName != "" {
+		klog.Infof("Creating transcode jobs as user %s (groups: %v)", imp.UserName, imp.Groups)
+		kubeClient, err = kubernetes.NewForConfig(impersonatedConfig(cfg, imp))
+		if err != nil {
+			klog.Exitf("Error building impersonated Kubernetes clientset: %s", err)
+		}
+		if useImp, _ := strconv.ParseBool(os.Getenv("KUBE_PLEX_IMPERSONATE_METADATA")); useImp {
+			metaClient = kubeClient
+		}
+	}
 
-	m, err := FetchMetadata(ctx, kubeClient, podName, podNamespace)
+	podName, podNamespace, err := podIdentity(os.Getenv, serviceAccountNamespaceFile)
 	if err != nil {
-		klog.Exitf("Error when fetching PMS pod metadata: %v", err)
+		klog.Exitf("Unable to determine the PMS pod: %v", err)
+	}
+
+	hook, err := parsePostHook(os.Getenv("KUBE_PLEX_POST_HOOK"), os.Getenv("KUBE_PLEX_POST_HOOK_TIMEOUT"))
+	if err != nil {
+		klog.Exitf("Invalid post hook configuration: %v", err)
+	}
+
+	pollInterval, err := parseWaitStrategy(os.Getenv("KUBE_PLEX_WAIT_STRATEGY"), os.Getenv("KUBE_PLEX_POLL_INTERVAL"))
+	if err != nil {
+		klog.Exitf("Invalid wait strategy: %v", err)
+	}
+
+	features, err := parseFeatureGates(os.Getenv("KUBE_PLEX_FEATURES"))
+	if err != nil {
+		klog.Exitf("Invalid feature gates: %v", err)
+	}
+
+	allowedImages, err := parseImagePrefixes(os.Getenv("KUBE_PLEX_ALLOWED_IMAGE_PREFIXES"))
+	if err != nil {
+		klog.Exitf("Invalid allowed image prefixes: %v", err)
+	}
+
+	breaker, err := parseCircuitBreaker(os.Getenv("KUBE_PLEX_BREAKER_THRESHOLD"), os.Getenv("KUBE_PLEX_BREAKER_WINDOW"),
+		os.Getenv("KUBE_PLEX_BREAKER_COOLDOWN"), os.Getenv("KUBE_PLEX_BREAKER_FALLBACK"), os.Getenv("KUBE_PLEX_BREAKER_STATE"))
+	if err != nil {
+		klog.Exitf("Invalid circuit breaker configuration: %v", err)
+	}
+
+	// Transcode jobs can be paused cluster wide, e.g. while draining nodes. The
+	// check fails open so that API errors don't stop transcodes.
+	if ref := os.Getenv("KUBE_PLEX_MAINTENANCE_CONFIGMAP"); ref != "" {
+		fallback, err := parseMaintenanceFallback(os.Getenv("KUBE_PLEX_MAINTENANCE_FALLBACK"))
+		if err != nil {
+			klog.Exitf("Invalid maintenance configuration: %v", err)
+		}
+		mm, err := inMaintenance(ctx, metaClient, podNamespace, ref)
+		if err != nil {
+			klog.Errorf("Unable to check maintenance mode: %v", err)
+		}
+		switch {
+		case mm && fallback == maintenanceLocal:
+			klog.Info("Transcode jobs are paused for maintenance, transcoding locally")
+			bypassKubePlex(ctx)
+		case mm:
+			klog.Exitf("Transcode jobs are paused for maintenance")
+		}
+	}
+
+	// Tracing is a no-op unless an OTLP endpoint is configured
+	shutdownTracing, err := setupTracing(ctx)
+	if err != nil {
+		klog.Errorf("Tracing disabled: %v", err)
+	}
+	// klog.Exitf skips deferred calls, flush spans explicitly before exiting
+	exitf := func(format string, args ...interface{}) {
+		shutdownTracing(context.Background())
+		klog.Exitf(format, args...)
+	}
+	ctx, span := tracer.Start(traceParent(ctx), "Transcode")
+	defer shutdownTracing(context.Background())
+	defer span.End()
+
+	mctx, mspan := tracer.Start(ctx, "FetchMetadata", trace.WithAttributes(
+		semconv.K8SNamespaceNameKey.String(podNamespace),
+		semconv.K8SPodNameKey.String(podName),
+	))
+	m, err := FetchMetadata(mctx, metaClient, podName, podNamespace)
+	mspan.SetAttributes(semconv.ContainerImageNameKey.String(m.PmsImage))
+	endSpan(mspan, err)
+	if err != nil {
+		span.End()
+		exitf("Error when fetching PMS pod metadata: %v", err)
+	}
+
+	// Settings of experimental features fail the transcode unless enabled
+	if err := features.Check(featureSettings(m, os.Getenv)); err != nil {
+		span.End()
+		exitf("%v", err)
+	}
+
+	// Fleet wide pod spec defaults, below the namespace and pod settings
+	if ref := os.Getenv("KUBE_PLEX_DEFAULTS_CONFIGMAP"); ref != "" {
+		m.PodDefaults, err = loadPodDefaults(ctx, metaClient, podNamespace, ref)
+		if err != nil {
+			span.End()
+			exitf("Error loading pod defaults: %v", err)
+		}
+	}
+
+	if m.DataLocality {
+		m.Locality, err = dataLocality(ctx, metaClient, m.Namespace, m.Volumes)
+		if err != nil {
+			span.End()
+			exitf("Error looking up data locality: %v", err)
+		}
+	}
+
+	// The storage class check needs a cluster role, it's opt-in
+	if check, _ := strconv.ParseBool(os.Getenv("KUBE_PLEX_CHECK_STORAGE_CLASS")); check {
+		if err := checkScratchStorageClass(ctx, metaClient, m.ScratchVolumeSource()); err != nil {
+			span.End()
+			exitf("Error checking scratch volume: %v", err)
+		}
+	}
+
+	for _, vm := range m.VolumeMounts {
+		klog.Infof("Mounting volume %s at %s in transcode pod", vm.Name, vm.MountPath)
 	}
 
 	// Write codecPort to pmsMetadata
@@ -87,33 +208,142 @@ func main() {
 
 	cwd, err := os.Getwd()
 	if err != nil {
-		klog.Exitf("Error getting working directory: %s", err)
+		span.End()
+		exitf("Error getting working directory: %s", err)
 	}
 
 	env := os.Environ()
 	args := os.Args
+	if m.TraceEnv {
+		env = withTraceEnv(ctx, env)
+	}
 
 	job, err := generateJob(cwd, m, env, args)
 	if err != nil {
-		klog.Exitf("Error while generating Job: %v", err)
+		span.End()
+		exitf("Error while generating Job: %v", err)
+	}
+	if err := checkImages(job.Spec.Template.Spec, allowedImages); err != nil {
+		span.End()
+		exitf("Refusing to create transcode job: %v", err)
 	}
+	klog.V(1).Infof("Transcode launcher command: %s", shellQuote(m.LauncherCmd(args...)))
 
+	opts := runOptions{hook: hook, manifestDir: os.Getenv("KUBE_PLEX_MANIFEST_DIR"), pollInterval: pollInterval, breaker: breaker}
+	err = runTranscodeOOMRetry(ctx, kubeClient, m, job, opts)
+	if localFallback(err, m, breaker) {
+		klog.Infof("%v, transcoding locally", err)
+		span.End()
+		shutdownTracing(context.Background())
+		bypassKubePlex(ctx)
+	}
+	if err != nil {
+		endSpan(span, err)
+		exitf("Transcode failed: %v", err)
+	}
+}
+
+// localFallback reports whether the transcode has to run in the PMS pod after
+// the transcode job failed with err
+func localFallback(err error, m PmsMetadata, b *circuitBreaker) bool {
+	switch {
+	case errors.Is(err, ErrNotScheduled):
+		return true
+	case errors.Is(err, ErrJobsForbidden):
+		return m.LocalOnForbidden
+	case errors.Is(err, ErrCircuitOpen):
+		return b != nil && b.local
+	case errors.Is(err, ErrImagePull):
+		return m.PullErrors == pullErrorLocal
+	}
+	return false
+}
+
+// runOptions are kube-plex process level settings for running a transcode
+type runOptions struct {
+	hook         *postHook       // run after a successful transcode
+	manifestDir  string          // directory to write created job manifests to
+	pollInterval time.Duration   // poll the job instead of watching it if set
+	breaker      *circuitBreaker // pauses job creation after API failures if set
+}
+
+// runTranscode creates the transcode job and waits for it to complete. The job
+// is deleted before returning, unless it succeeded and successful jobs are kept.
+// ErrNotScheduled is returned when the pod of the job isn't scheduled within
+// the scheduling timeout, the transcode then has to be run locally.
+// ErrJobsForbidden is returned when the job can't be created due to RBAC and
+// ErrNamespaceTerminating when the namespace is being deleted. ErrCircuitOpen
+// is returned without creating the job while the circuit breaker is open.
+// ErrImagePull is returned when an image of the pod can't be pulled, unless
+// image pull errors are waited on. ErrVolumeMount is returned when volume
+// attach or mount failures persist for the mount timeout. The error of a
+// failed job includes the latest warning events of its pod, ErrOOMKilled is
+// matched when the transcoder ran out of memory. ErrClaimUnbound is returned
+// without creating the job when a PVC isn't bound within the bind timeout.
+func runTranscode(ctx context.Context, cl kubernetes.Interface, m PmsMetadata, job *batch.Job, opts runOptions) error {
 	klog.Infof("Starting transcode job")
+	start := clk.Now()
+
+	if err := opts.breaker.allow(); err != nil {
+		return err
+	}
 
-	job, err = kubeClient.BatchV1().Jobs(job.Namespace).Create(ctx, job, metav1.CreateOptions{})
+	if m.QuotaPrecheck {
+		if err := checkQuota(ctx, cl, job); err != nil {
+			return err
+		}
+	}
+	if m.MaxTranscodes > 0 {
+		if err := checkConcurrentTranscodes(ctx, cl, job.Namespace, m.MaxTranscodes); err != nil {
+			return err
+		}
+	}
+	if claims := podClaims(job.Spec.Template.Spec); m.PVCBindTimeout > 0 && len(claims) > 0 {
+		if err := waitForClaimsBound(ctx, cl, job.Namespace, claims, m.PVCBindTimeout); err != nil {
+			return err
+		}
+	}
+
+	cctx, span := tracer.Start(ctx, "CreateJob", trace.WithAttributes(
+		semconv.K8SNamespaceNameKey.String(job.Namespace),
+		semconv.ContainerImageNameKey.String(m.PmsImage),
+	))
+	job, err := createJob(cctx, cl, job)
+	opts.breaker.record(err)
+	if errors.Is(err, ErrJobsUnavailable) || errors.Is(err, ErrJobsForbidden) || errors.Is(err, ErrNamespaceTerminating) {
+		endSpan(span, err)
+		return err
+	}
 	if err != nil {
-		klog.Exitf("Error creating pod: %s", err)
+		endSpan(span, err)
+		return fmt.Errorf("error creating pod: %v", err)
+	}
+	span.SetAttributes(semconv.K8SJobNameKey.String(job.Name))
+	span.End()
+
+	// Manifests are for auditing only, the transcode goes on without them
+	if opts.manifestDir != "" {
+		if f, err := writeManifest(opts.manifestDir, job, clk.Now()); err != nil {
+			klog.Errorf("Error writing job manifest: %v", err)
+		} else {
+			klog.V(1).Infof("Wrote job manifest to %s", f)
+		}
 	}
 
 	// Set up job deletion
+	succeeded := false
 	defer func() {
+		if succeeded && m.KeepSuccessful {
+			klog.Infof("Keeping job/%s after successful transcode", job.Name)
+			return
+		}
 		// start new context for cleanup since old one should already be done
 		ctx := context.Background()
 		klog.Infof("Cleaning up pod...")
 		bg := metav1.DeletePropagationBackground
-		err = kubeClient.BatchV1().Jobs(job.Namespace).Delete(ctx, job.Name, metav1.DeleteOptions{PropagationPolicy: &bg})
+		err := cl.BatchV1().Jobs(job.Namespace).Delete(ctx, job.Name, metav1.DeleteOptions{PropagationPolicy: &bg})
 		if err != nil {
-			klog.Exitf("Error cleaning up pod: %s", err)
+			klog.Errorf("Error cleaning up pod: %s", err)
 		}
 	}()
 
@@ -122,22 +352,161 @@ func main() {
 	ctx, stop := signal.NotifyContext(ctx, shutdownSignals...)
 	defer stop()
 
-	waitCh := make(chan error)
+	waitCtx, cancelWait := context.WithCancel(ctx)
+	defer cancelWait()
+
+	waitCtx, span = tracer.Start(waitCtx, "WaitForCompletion", trace.WithAttributes(
+		semconv.K8SNamespaceNameKey.String(job.Namespace),
+		semconv.K8SJobNameKey.String(job.Name),
+	))
+	waitCh := make(chan error, 1)
 	go func() {
-		waitCh <- waitForPodCompletion(ctx, kubeClient, job)
+		if opts.pollInterval > 0 {
+			waitCh <- pollForPodCompletion(waitCtx, cl, job, opts.pollInterval)
+			return
+		}
+		waitCh <- waitForPodCompletion(waitCtx, cl, job, m.WatchTimeout)
 	}()
 
-	select {
-	case err := <-waitCh:
-		if err != nil {
-			klog.Infof("Error waiting for pod to complete: %s", err)
+	// Maximum lifetime is enforced here as well as with the job deadline, in
+	// case the cluster fails to terminate the job in time
+	var lifetimeCh <-chan time.Time
+	if m.MaxLifetime > 0 {
+		t := clk.NewTimer(m.MaxLifetime)
+		defer t.Stop()
+		lifetimeCh = t.C()
+	}
+
+	// The scheduling of the pod is checked once the scheduling timeout expires
+	var schedCh <-chan time.Time
+	if m.ScheduleTimeout > 0 {
+		t := clk.NewTimer(m.ScheduleTimeout)
+		defer t.Stop()
+		schedCh = t.C()
+	}
+
+	// Image pull errors are checked until the pod starts, the job would
+	// otherwise wait for the image pull back-off indefinitely
+	var pullCh <-chan time.Time
+	pullTimer := clk.NewTimer(imagePullInterval)
+	defer pullTimer.Stop()
+	if m.PullErrors != pullErrorWait {
+		pullCh = pullTimer.C()
+	}
+
+	// Volume attach and mount failures are tolerated until the pod starts or
+	// they persist for the mount timeout
+	var mountCh <-chan time.Time
+	var mountFailing time.Time
+	mountTimer := clk.NewTimer(volumeCheckInterval)
+	defer mountTimer.Stop()
+	if m.MountTimeout > 0 {
+		mountCh = mountTimer.C()
+	}
+
+wait:
+	for {
+		select {
+		case err = <-waitCh:
+			succeeded = err == nil
+			break wait
+		case <-waitCtx.Done():
+			err = waitCtx.Err()
+			break wait
+		case <-lifetimeCh:
+			err = fmt.Errorf("transcode exceeded maximum lifetime of %v", m.MaxLifetime)
+			endSpan(span, err)
+			return err
+		case <-schedCh:
+			pods, err := jobPods(ctx, cl, job)
+			if err != nil {
+				klog.Errorf("Unable to check scheduling of job/%s: %v", job.Name, err)
+				continue
+			}
+			if !podsScheduled(pods) {
+				err = fmt.Errorf("%w: job/%s not scheduled within %v", ErrNotScheduled, job.Name, m.ScheduleTimeout)
+				endSpan(span, err)
+				return err
+			}
+		case <-pullCh:
+			pods, err := jobPods(ctx, cl, job)
+			if err != nil {
+				klog.Errorf("Unable to check image pulls of job/%s: %v", job.Name, err)
+				pullTimer.Reset(imagePullInterval)
+				continue
+			}
+			if err := imagePullError(pods); err != nil {
+				endSpan(span, err)
+				return err
+			}
+			if podsStarted(pods) {
+				pullCh = nil
+				continue
+			}
+			pullTimer.Reset(imagePullInterval)
+		case <-mountCh:
+			pods, err := jobPods(ctx, cl, job)
+			if err != nil {
+				klog.Errorf("Unable to check volumes of job/%s: %v", job.Name, err)
+				mountTimer.Reset(volumeCheckInterval)
+				continue
+			}
+			if podsStarted(pods) {
+				mountCh = nil
+				continue
+			}
+			events, err := podEvents(ctx, cl, pods)
+			if err != nil {
+				klog.Errorf("Unable to check volumes of job/%s: %v", job.Name, err)
+				mountTimer.Reset(volumeCheckInterval)
+				continue
+			}
+			switch msg := volumeFailure(events); {
+			case msg == "":
+				mountFailing = time.Time{}
+			case mountFailing.IsZero():
+				klog.Infof("Volume of job/%s not ready, waiting up to %v: %s", job.Name, m.MountTimeout, msg)
+				mountFailing = clk.Now()
+			case clk.Since(mountFailing) >= m.MountTimeout:
+				err = fmt.Errorf("%w: job/%s: %s", ErrVolumeMount, job.Name, msg)
+				endSpan(span, err)
+				return err
+			}
+			mountTimer.Reset(volumeCheckInterval)
+		}
+	}
+	endSpan(span, err)
+
+	switch {
+	case ctx.Err() != nil:
+		klog.Infof("Context terminated with error: %v", ctx.Err())
+	case err != nil:
+		klog.Infof("Error waiting for pod to complete: %s", err)
+		pods, perr := jobPods(ctx, cl, job)
+		if perr != nil {
+			return err
+		}
+		if msg := terminationMessage(pods, m.TranscodeContainerName()); msg != "" {
+			klog.Errorf("Transcoder of job/%s terminated: %s", job.Name, msg)
+		}
+		if oomKilled(pods, m.TranscodeContainerName()) {
+			err = fmt.Errorf("%w: job/%s: %v", ErrOOMKilled, job.Name, err)
+		}
+		// The cause of a failed pod (FailedScheduling, volume errors, evictions)
+		// is often only found in its events
+		events, eerr := podEvents(ctx, cl, pods)
+		if eerr != nil {
+			klog.Errorf("Unable to fetch events of job/%s: %v", job.Name, eerr)
+			return err
 		}
-	case <-ctx.Done():
-		if ctx.Err() != nil {
-			klog.Infof("Context terminated with error:", ctx.Err())
+		if w := recentWarnings(events, failureEvents); len(w) > 0 {
+			return fmt.Errorf("%w, pod events: %s", err, strings.Join(w, "; "))
 		}
+		return err
+	case succeeded && opts.hook != nil:
+		opts.hook.run(ctx, newHookSession(ctx, cl, m, job, clk.Since(start)))
 	}
-	stop()
+	return nil
 }
 
 // Checks if bypass is needed
@@ -1,10 +1,23 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/go-logr/logr"
+	batch "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
 	"k8s.io/klog/v2"
 )
 
@@ -63,3 +76,305 @@ func Test_needBypass(t *testing.T) {
 		})
 	}
 }
+
+func Test_localFallback(t *testing.T) {
+	local := &circuitBreaker{local: true}
+	wrap := func(err error) error { return fmt.Errorf("%w: details", err) }
+	tests := []struct {
+		name    string
+		err     error
+		m       PmsMetadata
+		breaker *circuitBreaker
+		want    bool
+	}{
+		{"success", nil, PmsMetadata{LocalOnForbidden: true, PullErrors: pullErrorLocal}, local, false},
+		{"other error", errors.New("error creating pod"), PmsMetadata{LocalOnForbidden: true, PullErrors: pullErrorLocal}, local, false},
+		{"not scheduled", wrap(ErrNotScheduled), PmsMetadata{}, nil, true},
+		{"forbidden with fallback", wrap(ErrJobsForbidden), PmsMetadata{LocalOnForbidden: true}, nil, true},
+		{"forbidden without fallback", wrap(ErrJobsForbidden), PmsMetadata{}, local, false},
+		{"circuit open with local breaker", wrap(ErrCircuitOpen), PmsMetadata{}, local, true},
+		{"circuit open with failing breaker", wrap(ErrCircuitOpen), PmsMetadata{LocalOnForbidden: true}, &circuitBreaker{}, false},
+		{"circuit open without breaker", wrap(ErrCircuitOpen), PmsMetadata{}, nil, false},
+		{"image pull with local", wrap(ErrImagePull), PmsMetadata{PullErrors: pullErrorLocal}, nil, true},
+		{"image pull with fail", wrap(ErrImagePull), PmsMetadata{PullErrors: pullErrorFail}, local, false},
+		{"image pull with default", wrap(ErrImagePull), PmsMetadata{LocalOnForbidden: true}, local, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := localFallback(tt.err, tt.m, tt.breaker); got != tt.want {
+				t.Errorf("localFallback() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_runTranscode(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	t.Run("exceeds maximum lifetime", func(t *testing.T) {
+		fc := fakeClock(t)
+		cl := fake.NewSimpleClientset()
+		job := &batch.Job{ObjectMeta: metav1.ObjectMeta{Name: "job", Namespace: "plex"}}
+
+		errCh := make(chan error, 1)
+		go func() { errCh <- runTranscode(ctx, cl, PmsMetadata{MaxLifetime: time.Hour}, job, runOptions{}) }()
+		waitForTimer(t, fc)
+		fc.Step(time.Hour)
+		if err := <-errCh; err == nil {
+			t.Errorf("runTranscode() returned success, expected timeout error")
+		}
+
+		if _, err := cl.BatchV1().Jobs("plex").Get(ctx, "job", metav1.GetOptions{}); err == nil {
+			t.Errorf("runTranscode() did not clean up the job")
+		}
+	})
+
+	t.Run("completes", func(t *testing.T) {
+		cl := fake.NewSimpleClientset()
+		job := &batch.Job{ObjectMeta: metav1.ObjectMeta{Name: "job", Namespace: "plex"}, Status: batch.JobStatus{Succeeded: 1}}
+
+		if err := runTranscode(ctx, cl, PmsMetadata{MaxLifetime: time.Minute}, job, runOptions{}); err != nil {
+			t.Errorf("runTranscode() error = %v, want nil", err)
+		}
+		if _, err := cl.BatchV1().Jobs("plex").Get(ctx, "job", metav1.GetOptions{}); err == nil {
+			t.Errorf("runTranscode() did not clean up the job")
+		}
+	})
+
+	t.Run("keeps successful job", func(t *testing.T) {
+		cl := fake.NewSimpleClientset()
+		job := &batch.Job{ObjectMeta: metav1.ObjectMeta{Name: "job", Namespace: "plex"}, Status: batch.JobStatus{Succeeded: 1}}
+
+		if err := runTranscode(ctx, cl, PmsMetadata{KeepSuccessful: true}, job, runOptions{}); err != nil {
+			t.Errorf("runTranscode() error = %v, want nil", err)
+		}
+		if _, err := cl.BatchV1().Jobs("plex").Get(ctx, "job", metav1.GetOptions{}); err != nil {
+			t.Errorf("runTranscode() removed successful job: %v", err)
+		}
+	})
+
+	t.Run("writes manifest", func(t *testing.T) {
+		cl := fake.NewSimpleClientset()
+		job := &batch.Job{ObjectMeta: metav1.ObjectMeta{Name: "job", Namespace: "plex"}, Status: batch.JobStatus{Succeeded: 1}}
+		dir := t.TempDir()
+
+		if err := runTranscode(ctx, cl, PmsMetadata{}, job, runOptions{manifestDir: dir}); err != nil {
+			t.Errorf("runTranscode() error = %v, want nil", err)
+		}
+		if m, _ := filepath.Glob(filepath.Join(dir, "job-*.yaml")); len(m) != 1 {
+			t.Errorf("runTranscode() wrote manifests %v, want one", m)
+		}
+	})
+
+	t.Run("manifest errors are ignored", func(t *testing.T) {
+		cl := fake.NewSimpleClientset()
+		job := &batch.Job{ObjectMeta: metav1.ObjectMeta{Name: "job", Namespace: "plex"}, Status: batch.JobStatus{Succeeded: 1}}
+
+		if err := runTranscode(ctx, cl, PmsMetadata{}, job, runOptions{manifestDir: "/nonexistent/kube-plex"}); err != nil {
+			t.Errorf("runTranscode() error = %v, want nil", err)
+		}
+	})
+
+	t.Run("not scheduled in time", func(t *testing.T) {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "job-abcde", Namespace: "plex", Labels: map[string]string{"job-name": "job"}},
+			Status: corev1.PodStatus{Phase: corev1.PodPending, Conditions: []corev1.PodCondition{{Type: corev1.PodScheduled, Status: corev1.ConditionFalse, Reason: "Unschedulable"}}}}
+		cl := fake.NewSimpleClientset(pod)
+		job := &batch.Job{ObjectMeta: metav1.ObjectMeta{Name: "job", Namespace: "plex"}}
+		fc := fakeClock(t)
+
+		errCh := make(chan error, 1)
+		go func() { errCh <- runTranscode(ctx, cl, PmsMetadata{ScheduleTimeout: time.Minute}, job, runOptions{}) }()
+		waitForTimer(t, fc)
+		fc.Step(time.Minute)
+		if err := <-errCh; !errors.Is(err, ErrNotScheduled) {
+			t.Errorf("runTranscode() error = %v, want %v", err, ErrNotScheduled)
+		}
+		if _, err := cl.BatchV1().Jobs("plex").Get(ctx, "job", metav1.GetOptions{}); err == nil {
+			t.Errorf("runTranscode() did not clean up the job")
+		}
+	})
+
+	t.Run("image pull error", func(t *testing.T) {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "job-abcde", Namespace: "plex", Labels: map[string]string{"job-name": "job"}},
+			Status: corev1.PodStatus{Phase: corev1.PodPending, ContainerStatuses: []corev1.ContainerStatus{
+				{Name: "plex", State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ImagePullBackOff", Message: "Back-off pulling image \"pms:missing\""}}},
+			}}}
+		cl := fake.NewSimpleClientset(pod)
+		job := &batch.Job{ObjectMeta: metav1.ObjectMeta{Name: "job", Namespace: "plex"}}
+		fc := fakeClock(t)
+
+		errCh := make(chan error, 1)
+		go func() { errCh <- runTranscode(ctx, cl, PmsMetadata{}, job, runOptions{}) }()
+		waitForTimer(t, fc)
+		fc.Step(imagePullInterval)
+		err := <-errCh
+		if !errors.Is(err, ErrImagePull) {
+			t.Fatalf("runTranscode() error = %v, want %v", err, ErrImagePull)
+		}
+		if !strings.Contains(err.Error(), `Back-off pulling image "pms:missing"`) {
+			t.Errorf("runTranscode() error = %v, want pull message", err)
+		}
+		if _, err := cl.BatchV1().Jobs("plex").Get(ctx, "job", metav1.GetOptions{}); err == nil {
+			t.Errorf("runTranscode() did not clean up the job")
+		}
+	})
+
+	t.Run("image pull errors waited on", func(t *testing.T) {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "job-abcde", Namespace: "plex", Labels: map[string]string{"job-name": "job"}},
+			Status: corev1.PodStatus{Phase: corev1.PodPending, ContainerStatuses: []corev1.ContainerStatus{
+				{Name: "plex", State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ErrImagePull"}}},
+			}}}
+		cl := fake.NewSimpleClientset(pod)
+		job := &batch.Job{ObjectMeta: metav1.ObjectMeta{Name: "job", Namespace: "plex"}}
+		fc := fakeClock(t)
+
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- runTranscode(ctx, cl, PmsMetadata{PullErrors: pullErrorWait, MaxLifetime: time.Minute}, job, runOptions{})
+		}()
+		waitForTimer(t, fc)
+		fc.Step(time.Minute)
+		if err := <-errCh; err == nil || errors.Is(err, ErrImagePull) {
+			t.Errorf("runTranscode() error = %v, want maximum lifetime error", err)
+		}
+	})
+
+	t.Run("transient volume mount failure", func(t *testing.T) {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "job-abcde", Namespace: "plex", Labels: map[string]string{"job-name": "job"}},
+			Status: corev1.PodStatus{Phase: corev1.PodPending}}
+		event := &corev1.Event{ObjectMeta: metav1.ObjectMeta{Name: "job-abcde.1", Namespace: "plex"},
+			InvolvedObject: corev1.ObjectReference{Kind: "Pod", Name: "job-abcde", Namespace: "plex"},
+			Type:           corev1.EventTypeWarning, Reason: "FailedAttachVolume", Message: "AttachVolume.Attach failed for volume \"media\""}
+		cl := fake.NewSimpleClientset(pod, event)
+		// The volume gets attached after the failure has been seen, the pod
+		// starts and the transcode completes
+		cl.PrependReactor("list", "events", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			running := pod.DeepCopy()
+			running.Status.Phase = corev1.PodRunning
+			if err := cl.Tracker().Update(corev1.SchemeGroupVersion.WithResource("pods"), running, "plex"); err != nil {
+				t.Errorf("updating pod: %v", err)
+			}
+			done := &batch.Job{ObjectMeta: metav1.ObjectMeta{Name: "job", Namespace: "plex"}, Status: batch.JobStatus{Succeeded: 1}}
+			if err := cl.Tracker().Update(batch.SchemeGroupVersion.WithResource("jobs"), done, "plex"); err != nil {
+				t.Errorf("updating job: %v", err)
+			}
+			return false, nil, nil
+		})
+		job := &batch.Job{ObjectMeta: metav1.ObjectMeta{Name: "job", Namespace: "plex"}}
+		fc := fakeClock(t)
+
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- runTranscode(ctx, cl, PmsMetadata{MountTimeout: time.Minute}, job, runOptions{pollInterval: volumeCheckInterval})
+		}()
+		if err := stepUntilDone(t, fc, volumeCheckInterval, errCh); err != nil {
+			t.Errorf("runTranscode() error = %v, want nil", err)
+		}
+	})
+
+	t.Run("volume mount timeout", func(t *testing.T) {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "job-abcde", Namespace: "plex", Labels: map[string]string{"job-name": "job"}},
+			Status: corev1.PodStatus{Phase: corev1.PodPending}}
+		event := &corev1.Event{ObjectMeta: metav1.ObjectMeta{Name: "job-abcde.1", Namespace: "plex"},
+			InvolvedObject: corev1.ObjectReference{Kind: "Pod", Name: "job-abcde", Namespace: "plex"},
+			Type:           corev1.EventTypeWarning, Reason: "FailedMount", Message: "MountVolume.SetUp failed for volume \"media\""}
+		cl := fake.NewSimpleClientset(pod, event)
+		job := &batch.Job{ObjectMeta: metav1.ObjectMeta{Name: "job", Namespace: "plex"}}
+		fc := fakeClock(t)
+
+		errCh := make(chan error, 1)
+		go func() { errCh <- runTranscode(ctx, cl, PmsMetadata{MountTimeout: time.Minute}, job, runOptions{}) }()
+		err := stepUntilDone(t, fc, volumeCheckInterval, errCh)
+		if !errors.Is(err, ErrVolumeMount) {
+			t.Fatalf("runTranscode() error = %v, want %v", err, ErrVolumeMount)
+		}
+		if !strings.Contains(err.Error(), `FailedMount: MountVolume.SetUp failed for volume "media"`) {
+			t.Errorf("runTranscode() error = %v, want mount failure message", err)
+		}
+		if _, err := cl.BatchV1().Jobs("plex").Get(ctx, "job", metav1.GetOptions{}); err == nil {
+			t.Errorf("runTranscode() did not clean up the job")
+		}
+	})
+
+	t.Run("scheduled in time", func(t *testing.T) {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "job-abcde", Namespace: "plex", Labels: map[string]string{"job-name": "job"}},
+			Spec: corev1.PodSpec{NodeName: "node1"}, Status: corev1.PodStatus{Phase: corev1.PodPending}}
+		cl := fake.NewSimpleClientset(pod)
+		job := &batch.Job{ObjectMeta: metav1.ObjectMeta{Name: "job", Namespace: "plex"}}
+
+		err := runTranscode(ctx, cl, PmsMetadata{ScheduleTimeout: 10 * time.Millisecond, MaxLifetime: 50 * time.Millisecond}, job, runOptions{})
+		if err == nil || errors.Is(err, ErrNotScheduled) {
+			t.Errorf("runTranscode() error = %v, want maximum lifetime error", err)
+		}
+	})
+
+	t.Run("job creation forbidden", func(t *testing.T) {
+		cl := fake.NewSimpleClientset()
+		cl.PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, apierrors.NewForbidden(batch.Resource("jobs"), "", errors.New(`User "system:serviceaccount:plex:default" cannot create resource "jobs" in API group "batch" in the namespace "plex"`))
+		})
+		job := &batch.Job{ObjectMeta: metav1.ObjectMeta{Name: "job", Namespace: "plex"}}
+
+		if err := runTranscode(ctx, cl, PmsMetadata{}, job, runOptions{}); !errors.Is(err, ErrJobsForbidden) {
+			t.Errorf("runTranscode() error = %v, want %v", err, ErrJobsForbidden)
+		}
+	})
+
+	t.Run("circuit breaker open", func(t *testing.T) {
+		cl := fake.NewSimpleClientset()
+		cl.PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, apierrors.NewServiceUnavailable("overloaded")
+		})
+		b := &circuitBreaker{path: filepath.Join(t.TempDir(), "state.json"), threshold: 1, window: time.Minute, cooldown: time.Minute}
+		job := &batch.Job{ObjectMeta: metav1.ObjectMeta{Name: "job", Namespace: "plex"}}
+
+		if err := runTranscode(ctx, cl, PmsMetadata{}, job, runOptions{breaker: b}); err == nil || errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("runTranscode() error = %v, want API error", err)
+		}
+		cl.ClearActions()
+		if err := runTranscode(ctx, cl, PmsMetadata{}, job, runOptions{breaker: b}); !errors.Is(err, ErrCircuitOpen) {
+			t.Errorf("runTranscode() error = %v, want %v", err, ErrCircuitOpen)
+		}
+		if a := cl.Actions(); len(a) != 0 {
+			t.Errorf("runTranscode() called the API with an open circuit breaker: %v", a)
+		}
+	})
+
+	t.Run("removes failed job", func(t *testing.T) {
+		cl := fake.NewSimpleClientset()
+		job := &batch.Job{ObjectMeta: metav1.ObjectMeta{Name: "job", Namespace: "plex"}, Status: batch.JobStatus{Failed: 1}}
+
+		_ = runTranscode(ctx, cl, PmsMetadata{KeepSuccessful: true}, job, runOptions{})
+		if _, err := cl.BatchV1().Jobs("plex").Get(ctx, "job", metav1.GetOptions{}); err == nil {
+			t.Errorf("runTranscode() kept failed job")
+		}
+	})
+
+	t.Run("failed job includes pod events", func(t *testing.T) {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "job-abcde", Namespace: "plex", Labels: map[string]string{"job-name": "job"}},
+			Status: corev1.PodStatus{Phase: corev1.PodFailed}}
+		at := func(min int) metav1.Time { return metav1.NewTime(time.Date(2021, 5, 1, 12, min, 0, 0, time.UTC)) }
+		event := func(name, pod, typ, reason, msg string, min int) *corev1.Event {
+			return &corev1.Event{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "plex"},
+				InvolvedObject: corev1.ObjectReference{Kind: "Pod", Name: pod, Namespace: "plex"},
+				Type:           typ, Reason: reason, Message: msg, LastTimestamp: at(min)}
+		}
+		cl := fake.NewSimpleClientset(pod,
+			event("job-abcde.3", "job-abcde", corev1.EventTypeWarning, "Evicted", "The node was low on resource: memory.", 3),
+			event("job-abcde.1", "job-abcde", corev1.EventTypeWarning, "FailedScheduling", "0/3 nodes are available: 3 Insufficient memory.", 1),
+			event("job-abcde.2", "job-abcde", corev1.EventTypeNormal, "Scheduled", "Successfully assigned plex/job-abcde to node1", 2),
+			event("other.1", "other", corev1.EventTypeWarning, "BackOff", "Back-off restarting failed container", 2),
+		)
+		job := &batch.Job{ObjectMeta: metav1.ObjectMeta{Name: "job", Namespace: "plex"}, Status: batch.JobStatus{Failed: 1}}
+
+		err := runTranscode(ctx, cl, PmsMetadata{}, job, runOptions{})
+		if err == nil {
+			t.Fatalf("runTranscode() returned success for failed job")
+		}
+		want := "pod events: FailedScheduling: 0/3 nodes are available: 3 Insufficient memory.; Evicted: The node was low on resource: memory."
+		if !strings.HasSuffix(err.Error(), want) {
+			t.Errorf("runTranscode() error = %v, want suffix %q", err, want)
+		}
+	})
+}
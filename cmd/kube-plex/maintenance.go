@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// maintenanceMode is the annotation on the maintenance ConfigMap that pauses
+// creation of transcode jobs
+const maintenanceMode = "kube-plex/maintenance-mode"
+
+// Fallbacks when transcode jobs are paused for maintenance
+const (
+	maintenanceFail  = "fail"
+	maintenanceLocal = "local"
+)
+
+// parseMaintenanceFallback validates the fallback for maintenance mode,
+// failing the transcode is the default
+func parseMaintenanceFallback(v string) (string, error) {
+	switch v {
+	case "":
+		return maintenanceFail, nil
+	case maintenanceFail, maintenanceLocal:
+		return v, nil
+	}
+	return "", fmt.Errorf("invalid maintenance fallback `%s`, expecting %s or %s", v, maintenanceFail, maintenanceLocal)
+}
+
+// inMaintenance reports whether transcode job creation is paused with the
+// maintenance mode annotation of a ConfigMap. The ConfigMap is referenced as
+// `name` or `namespace/name`, a missing ConfigMap means no maintenance.
+func inMaintenance(ctx context.Context, cl kubernetes.Interface, namespace, ref string) (bool, error) {
+	namespace, name, err := configMapRef(namespace, ref)
+	if err != nil {
+		return false, err
+	}
+
+	cm, err := cl.CoreV1().ConfigMaps(namespace).Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("unable to fetch ConfigMap %s/%s: %w", namespace, name, err)
+	}
+	return parseBoolAnnotation(cm.GetAnnotations(), maintenanceMode)
+}
+
+// configMapRef splits a ConfigMap reference, `name` or `namespace/name`, names
+// without a namespace are in namespace
+func configMapRef(namespace, ref string) (string, string, error) {
+	name := ref
+	if i := strings.Index(ref, "/"); i >= 0 {
+		namespace, name = ref[:i], ref[i+1:]
+	}
+	if namespace == "" || name == "" {
+		return "", "", fmt.Errorf("invalid ConfigMap reference `%s`", ref)
+	}
+	return namespace, name, nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func Test_parseMaintenanceFallback(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"", "fail", false},
+		{"fail", "fail", false},
+		{"local", "local", false},
+		{"retry", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseMaintenanceFallback(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseMaintenanceFallback() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseMaintenanceFallback() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_inMaintenance(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cl := fake.NewSimpleClientset(
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "plex", Name: "paused", Annotations: map[string]string{"kube-plex/maintenance-mode": "true"}}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "plex", Name: "running", Annotations: map[string]string{"kube-plex/maintenance-mode": "false"}}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "plex", Name: "unset"}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "plex", Name: "invalid", Annotations: map[string]string{"kube-plex/maintenance-mode": "soon"}}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "ops", Name: "cluster", Annotations: map[string]string{"kube-plex/maintenance-mode": "true"}}},
+	)
+
+	tests := []struct {
+		name    string
+		ref     string
+		want    bool
+		wantErr bool
+	}{
+		{"maintenance", "paused", true, false},
+		{"no maintenance", "running", false, false},
+		{"annotation missing", "unset", false, false},
+		{"configmap missing", "missing", false, false},
+		{"invalid annotation", "invalid", false, true},
+		{"other namespace", "ops/cluster", true, false},
+		{"invalid reference", "ops/", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := inMaintenance(ctx, cl, "plex", tt.ref)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("inMaintenance() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("inMaintenance() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
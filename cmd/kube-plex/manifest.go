@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	batch "k8s.io/api/batch/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// writeManifest writes the job as a YAML manifest to dir, named after the job
+// and the given time. The file is written under a temporary name and renamed
+// once complete so that archivers watching the directory never see partial
+// manifests.
+func writeManifest(dir string, job *batch.Job, now time.Time) (string, error) {
+	j := job.DeepCopy()
+	j.APIVersion, j.Kind = "batch/v1", "Job"
+	b, err := yaml.Marshal(j)
+	if err != nil {
+		return "", fmt.Errorf("unable to marshal job: %w", err)
+	}
+
+	name := filepath.Join(dir, fmt.Sprintf("%s-%s.yaml", job.Name, now.UTC().Format("20060102T150405Z")))
+	tmp := filepath.Join(dir, "."+filepath.Base(name)+".tmp")
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return "", fmt.Errorf("unable to write manifest: %w", err)
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		os.Remove(tmp)
+		return "", fmt.Errorf("unable to write manifest: %w", err)
+	}
+	return name, nil
+}
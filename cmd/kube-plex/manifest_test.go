@@ -0,0 +1,50 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	batch "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+func Test_writeManifest(t *testing.T) {
+	dir := t.TempDir()
+	job := &batch.Job{ObjectMeta: metav1.ObjectMeta{Name: "pms-elastic-transcoder-abc", Namespace: "plex"}}
+	now := time.Date(2021, 5, 1, 12, 30, 0, 0, time.UTC)
+
+	f, err := writeManifest(dir, job, now)
+	if err != nil {
+		t.Fatalf("writeManifest() error = %v", err)
+	}
+	if want := filepath.Join(dir, "pms-elastic-transcoder-abc-20210501T123000Z.yaml"); f != want {
+		t.Errorf("writeManifest() = %v, want %v", f, want)
+	}
+
+	b, err := os.ReadFile(f)
+	if err != nil {
+		t.Fatalf("unable to read manifest: %v", err)
+	}
+	var got batch.Job
+	if err := yaml.Unmarshal(b, &got); err != nil {
+		t.Fatalf("unable to parse manifest: %v", err)
+	}
+	if got.Kind != "Job" || got.APIVersion != "batch/v1" || got.Name != job.Name || got.Namespace != job.Namespace {
+		t.Errorf("manifest = %v/%v %v/%v, want batch/v1/Job plex/%v", got.APIVersion, got.Kind, got.Namespace, got.Name, job.Name)
+	}
+	if job.Kind != "" {
+		t.Errorf("writeManifest() modified the job")
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("manifest directory has %d files, want 1", len(entries))
+	}
+
+	if _, err := writeManifest(filepath.Join(dir, "missing"), job, now); err == nil {
+		t.Errorf("writeManifest() to missing directory succeeded, want error")
+	}
+}
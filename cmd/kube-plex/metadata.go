@@ -2,127 +2,1977 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"regexp"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/munnerz/kube-plex/internal/ffmpeg"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
 )
 
 const (
 	pmsURL                = "kube-plex/pms-addr"
+	pmsService            = "kube-plex/pms-service"
+	pmsServicePort        = "kube-plex/pms-service-port"
 	pmsContainer          = "kube-plex/pms-container-name"
+	pmsContainerIndex     = "kube-plex/pms-container-index"
 	pmsMounts             = "kube-plex/mounts"
+	pmsVolumeMounts       = "kube-plex/volume-mounts"
 	kubePlexLevel         = "kube-plex/loglevel"
 	kubePlexContainer     = "kube-plex/container-name"
 	kubePlexResourceReq   = "kube-plex/resources-requests"
 	kubePlexResourceLimit = "kube-plex/resources-limits"
+	kubePlexWatchTimeout  = "kube-plex/watch-timeout"
+	transcodeContainer    = "kube-plex/transcode-container-name"
+	kubePlexHostNetwork   = "kube-plex/host-network"
+	kubePlexUnresolved    = "kube-plex/allow-unresolved-image"
+	kubePlexExtraCont     = "kube-plex/extra-containers"
+	kubePlexMaxLifetime   = "kube-plex/max-lifetime"
+	kubePlexProfiles      = "kube-plex/resource-profiles"
+	kubePlexKeepSuccess   = "kube-plex/keep-successful-pods"
+	kubePlexSidecarImage  = "kube-plex/sidecar-image"
+	kubePlexSidecarArgs   = "kube-plex/sidecar-args"
+	kubePlexNoMesh        = "kube-plex/disable-mesh-injection"
+	kubePlexMeshLabels    = "kube-plex/mesh-injection-labels"
+	kubePlexMeshAnnots    = "kube-plex/mesh-injection-annotations"
+	kubePlexScratchCSI    = "kube-plex/transcode-csi"
+	kubePlexResolveWait   = "kube-plex/image-resolve-timeout"
+	kubePlexExtraRes      = "kube-plex/extra-resources"
+	kubePlexRunAsUser     = "kube-plex/run-as-user"
+	kubePlexRunAsGroup    = "kube-plex/run-as-group"
+	kubePlexPropLabels    = "kube-plex/propagate-labels"
+	kubePlexTranscoder    = "kube-plex/transcoder-path"
+	kubePlexSeccomp       = "kube-plex/seccomp-profile"
+	kubePlexAppArmor      = "kube-plex/apparmor-profile"
+	kubePlexNoDeschedule  = "kube-plex/prevent-descheduler-eviction"
+	kubePlexDeschedAnnots = "kube-plex/descheduler-annotations"
+	kubePlexCompleteFile  = "kube-plex/completion-file"
+	kubePlexPodOverhead   = "kube-plex/pod-overhead"
+	kubePlexServiceLinks  = "kube-plex/enable-service-links"
+	kubePlexArch          = "kube-plex/arch"
+	kubePlexCPURequest    = "kube-plex/cpu-request"
+	kubePlexCPULimit      = "kube-plex/cpu-limit"
+	kubePlexMemRequest    = "kube-plex/memory-request"
+	kubePlexMemLimit      = "kube-plex/memory-limit"
+	kubePlexNodeSelector  = "kube-plex/node-selector"
+	kubePlexPodTemplate   = "kube-plex/pod-template"
+	kubePlexCodecBind     = "kube-plex/codec-bind-mode"
+	kubePlexPDBLabel      = "kube-plex/pdb-label"
+	kubePlexOwnerRef      = "kube-plex/set-owner-reference"
+	kubePlexQuotaCheck    = "kube-plex/quota-precheck"
+	kubePlexPMSRetries    = "kube-plex/pms-reconnect-retries"
+	kubePlexPMSBackoff    = "kube-plex/pms-reconnect-backoff"
+	kubePlexPMSJitter     = "kube-plex/pms-reconnect-jitter"
+	kubePlexDevices       = "kube-plex/devices"
+	kubePlexRenderGroup   = "kube-plex/render-group-gid"
+	kubePlexOutputPVC     = "kube-plex/output-pvc"
+	kubePlexOutputPath    = "kube-plex/output-path"
+	kubePlexSchedLocal    = "kube-plex/schedule-or-local-timeout"
+	kubePlexLifecycle     = "kube-plex/transcode-lifecycle"
+	kubePlexUseInitImage  = "kube-plex/transcode-use-init-image"
+	kubePlexCodecRes      = "kube-plex/codec-resource-map"
+	kubePlexNoBackup      = "kube-plex/exclude-from-backup"
+	kubePlexBackupLabels  = "kube-plex/backup-exclusion-labels"
+	kubePlexBackupAnnots  = "kube-plex/backup-exclusion-annotations"
+	kubePlexRBACFallback  = "kube-plex/rbac-fallback-local"
+	kubePlexHostname      = "kube-plex/hostname"
+	kubePlexSubdomain     = "kube-plex/subdomain"
+	kubePlexDebugStdin    = "kube-plex/debug-stdin"
+	kubePlexDebugTTY      = "kube-plex/debug-tty"
+	kubePlexCodecWait     = "kube-plex/codec-server-wait-timeout"
+	kubePlexSharePID      = "kube-plex/share-process-namespace"
+	kubePlexScratchClaim  = "kube-plex/transcode-ephemeral-claim"
+	kubePlexTermPolicy    = "kube-plex/termination-message-policy"
+	kubePlexTermPath      = "kube-plex/termination-message-path"
+	kubePlexSysctls       = "kube-plex/sysctls"
+	kubePlexCodecURL      = "kube-plex/codec-server-url"
+	kubePlexTraceEnv      = "kube-plex/propagate-trace-context"
+	kubePlexAvoidPMSNode  = "kube-plex/avoid-pms-node"
+	kubePlexMaxTranscodes = "kube-plex/max-concurrent-transcodes"
+	kubePlexPullErrors    = "kube-plex/image-pull-error"
+	kubePlexPreemption    = "kube-plex/preemption-policy"
+	kubePlexMediaPVC      = "kube-plex/media-pvc-selector"
+	kubePlexMediaPath     = "kube-plex/media-pvc-path"
+	kubePlexLauncherMem   = "kube-plex/transcoder-max-memory"
+	kubePlexLauncherCPU   = "kube-plex/transcoder-max-cpu-time"
+	kubePlexStaticLabels  = "kube-plex/static-labels"
+	kubePlexDataLocality  = "kube-plex/data-locality"
+	kubePlexHTTPProxy     = "kube-plex/http-proxy"
+	kubePlexHTTPSProxy    = "kube-plex/https-proxy"
+	kubePlexNoProxy       = "kube-plex/no-proxy"
+	kubePlexMountTimeout  = "kube-plex/volume-mount-timeout"
+	kubePlexOOMBump       = "kube-plex/oom-auto-bump"
+	kubePlexOOMBumpMax    = "kube-plex/oom-auto-bump-max"
+	kubePlexTokenExpiry   = "kube-plex/token-expiration"
+	kubePlexRuntimeClass  = "kube-plex/runtime-class"
+	kubePlexInheritRC     = "kube-plex/inherit-runtime-class"
+	kubePlexScratchClass  = "kube-plex/scratch-storage-class"
+	kubePlexPMSFallback   = "kube-plex/pms-image-fallback"
+	kubePlexNetworks      = "kube-plex/networks"
+	kubePlexPVCBind       = "kube-plex/pvc-bind-timeout"
+	kubePlexGPUProduct    = "kube-plex/gpu-product"
+)
+
+// Prefixes of PMS pod labels (and namespace annotations) read as settings, see
+// podSettings, namespaceSettings and nodeSelectorLabels
+const (
+	settingPrefix      = "kube-plex/"
+	nodeSelectorPrefix = "node-selector.kube-plex/"
+)
+
+// Bounds of the expirationSeconds of projected service account tokens enforced
+// by the API server, the cluster may cap the expiration further
+const (
+	minTokenExpiry = 10 * time.Minute
+	maxTokenExpiry = (1 << 32) * time.Second
+)
+
+// multusNetworksAnnotation is the pod annotation Multus attaches additional
+// networks from
+const multusNetworksAnnotation = "k8s.v1.cni.cncf.io/networks"
+
+// defaultArch is the node architecture transcode pods are scheduled on unless
+// overridden with the kubePlexArch annotation
+const defaultArch = "amd64"
+
+// verboseLevels are transcoder log levels requested by Plex with verbose
+// logging enabled
+var verboseLevels = map[string]bool{"verbose": true, "debug": true, "trace": true}
+
+// Codec server URL host selection modes, see LauncherCmd
+const (
+	codecBindPodIP     = "pod-ip"
+	codecBindNodeIP    = "node-ip"
+	codecBindLocalhost = "localhost"
+)
+
+// supportedArchs are the architectures that can be set with kubePlexArch
+var supportedArchs = map[string]bool{"amd64": true, "arm64": true, "arm": true}
+
+// defaultTranscodeContainer is the name of the container in transcode pod
+// unless overridden with transcodeContainer annotation
+const defaultTranscodeContainer = "plex"
+
+// defaultOutputPath is where the output PVC is mounted in the transcode pod
+// unless overridden with the kubePlexOutputPath annotation
+const defaultOutputPath = "/output"
+
+// defaultMediaPath is where the PVC found by kubePlexMediaPVC is mounted in the
+// transcode pod
+const defaultMediaPath = "/data"
+
+// imageResolveInterval is the polling interval when waiting for the PMS
+// container image to be resolved
+var imageResolveInterval = time.Second
+
+// Labels and annotations that exclude the transcode pod from service mesh
+// sidecar injection, unless overridden with annotations
+var (
+	defaultMeshLabels      = map[string]string{"sidecar.istio.io/inject": "false"}
+	defaultMeshAnnotations = map[string]string{"sidecar.istio.io/inject": "false", "linkerd.io/inject": "disabled"}
+)
+
+// sysctlName matches sysctl names, with either dots or slashes as separators
+var sysctlName = regexp.MustCompile(`^([a-z0-9]([-_a-z0-9]*[a-z0-9])?[\./])*[a-z0-9]([-_a-z0-9]*[a-z0-9])?$`)
+
+// defaultBackupLabels exclude the transcode pod from Velero backups, unless
+// overridden with annotations
+var defaultBackupLabels = map[string]string{"velero.io/exclude-from-backup": "true"}
+
+// defaultDeschedulerAnnotations protect the transcode pod from Descheduler
+// evictions, unless overridden with annotations. Note that the
+// `descheduler.alpha.kubernetes.io/evict` annotation does the opposite, it
+// makes pods evictable.
+var defaultDeschedulerAnnotations = map[string]string{"descheduler.alpha.kubernetes.io/prefer-no-eviction": "true"}
+
+// sidecarContainer is the name of the sidecar container in transcode pod and
+// sidecarDoneFile the completion file of the launcher for the sidecar, unless
+// set with kubePlexCompleteFile
+const (
+	sidecarContainer = "kube-plex-sidecar"
+	sidecarDoneFile  = "/shared/transcode-done"
 )
 
 // PmsMetadata describes a Plex Media Server instance running in kubernetes.
 type PmsMetadata struct {
-	Name             string               // Pod Name
-	Namespace        string               // Pod Namespace
-	UID              types.UID            // Pod UID
-	PodIP            string               // Pod IP address
-	Mounts           []string             // List of mounts (paths) to copy to transcoder
-	VolumeMounts     []corev1.VolumeMount // kube-plex volume mounts
-	Volumes          []corev1.Volume      // kube-plex needed volumes
-	ResourceRequests corev1.ResourceList  // Resource requests definition for kube-plex
-	ResourceLimits   corev1.ResourceList  // Resource limits definition for kube-plex
-	KubePlexImage    string               // container image for kube-plex
-	KubePlexLevel    string               // loglevel of kubeplex processes
-	CodecPort        int                  // port on which the codec service runs
-	PmsImage         string               // container image used by Plex Media Server
-	PmsAddr          string               // URL for Plex Media Server
+	Name             string                                 // Pod Name
+	Namespace        string                                 // Pod Namespace
+	UID              types.UID                              // Pod UID
+	PodIP            string                                 // Pod IP address
+	NodeIP           string                                 // IP address of the node running the pod
+	Mounts           []string                               // List of mounts (paths) to copy to transcoder
+	VolumeMounts     []corev1.VolumeMount                   // kube-plex volume mounts
+	Volumes          []corev1.Volume                        // kube-plex needed volumes
+	ResourceRequests corev1.ResourceList                    // Resource requests definition for kube-plex
+	ResourceLimits   corev1.ResourceList                    // Resource limits definition for kube-plex
+	KubePlexImage    string                                 // container image for kube-plex
+	KubePlexLevel    string                                 // loglevel of kubeplex processes
+	CodecPort        int                                    // port on which the codec service runs
+	PmsImage         string                                 // container image used by Plex Media Server
+	PmsAddr          string                                 // URL for Plex Media Server
+	WatchTimeout     time.Duration                          // server side timeout for a single job watch request
+	TranscodeName    string                                 // name of the container in transcode pod
+	HostNetwork      bool                                   // run transcode pod in host network namespace
+	ExtraContainers  []corev1.Container                     // additional containers for transcode pod
+	MaxLifetime      time.Duration                          // maximum duration of a transcode job
+	ResourceProfiles map[string]corev1.ResourceRequirements // named resource profiles for transcodes
+	KeepSuccessful   bool                                   // skip cleanup of successful transcode jobs
+	Sidecar          *corev1.Container                      // sidecar running alongside the transcoder
+	PodLabels        map[string]string                      // additional labels for transcode pod
+	PodAnnotations   map[string]string                      // additional annotations for transcode pod
+	ScratchVolume    *corev1.VolumeSource                   // volume source for the shared scratch volume
+	ExtraResources   corev1.ResourceList                    // extended resources (e.g. devices) for transcode container
+	SecurityContext  *corev1.SecurityContext                // security context of the transcode container
+	TranscoderPath   string                                 // path of Plex Transcoder in transcode container
+	CompletionFile   string                                 // file the launcher writes its exit code to when done
+	PodOverhead      corev1.ResourceList                    // pod overhead of transcode pods
+	ServiceLinks     *bool                                  // enableServiceLinks of transcode pod, cluster default if nil
+	Arch             *string                                // node architecture of transcode pod, defaultArch if nil and any if empty
+	NodeSelector     map[string]string                      // additional node selector for transcode pod
+	PodTemplate      json.RawMessage                        // pod spec fragment merged onto the transcode pod spec
+	PodDefaults      json.RawMessage                        // pod spec fragment the transcode pod spec is merged onto
+	CodecBindMode    string                                 // how the codec server host is chosen, by host network if empty
+	NoOwnerReference bool                                   // don't set PMS pod as owner of transcode jobs
+	QuotaPrecheck    bool                                   // check resource quotas before creating the job
+	PmsRetries       int                                    // launcher retries of failed PMS connections
+	PmsBackoff       time.Duration                          // launcher delay before the first retry, launcher default if zero
+	PmsJitter        float64                                // launcher jitter added to retry delays, as a fraction of the delay
+	Devices          []string                               // host device paths mounted to transcode container
+	RenderGroup      *int64                                 // supplemental group of transcode pod for device access
+	OutputPVC        string                                 // claim name of the PVC mounted for transcode output
+	OutputPath       string                                 // mount path of the output PVC
+	ScheduleTimeout  time.Duration                          // transcode locally if the pod isn't scheduled in time
+	Lifecycle        *corev1.Lifecycle                      // lifecycle hooks of the transcode container
+	UseInitImage     bool                                   // run the transcode container with KubePlexImage instead of PmsImage
+	CodecResources   map[string]codecResources              // resource adjustments by output video codec
+	LocalOnForbidden bool                                   // transcode locally if RBAC forbids creating the job
+	Hostname         string                                 // hostname of the transcode pod
+	Subdomain        string                                 // subdomain of the transcode pod, the name of a headless service
+	DebugStdin       bool                                   // allocate stdin for the transcode container
+	DebugTTY         bool                                   // allocate a TTY for the transcode container
+	CodecWaitTimeout time.Duration                          // launcher wait for the codec server to become healthy
+	SharePID         *bool                                  // shareProcessNamespace of transcode pod, unset if nil
+	TermMsgPolicy    corev1.TerminationMessagePolicy        // termination message policy of transcode container
+	TermMsgPath      string                                 // termination message path of transcode container
+	Sysctls          []corev1.Sysctl                        // namespaced sysctls of transcode pod
+	CodecURL         string                                 // codec server URL replacing the one from CodecBindMode
+	TraceEnv         bool                                   // pass trace context to the transcoder environment
+	AvoidNode        string                                 // node preferably avoided by the transcode pod
+	MaxTranscodes    int                                    // concurrent transcode pods in namespace, unlimited if 0
+	PullErrors       string                                 // handling of image pull errors: fail, wait or local
+	Preemption       *corev1.PreemptionPolicy               // preemption policy of transcode pod
+	LauncherMem      int64                                  // address space limit of the transcoder set by the launcher
+	LauncherCPU      time.Duration                          // CPU time limit of the transcoder set by the launcher
+	DataLocality     bool                                   // prefer nodes holding the data of mounted PVCs
+	Locality         []corev1.NodeSelectorTerm              // preferred nodes holding the data of mounted PVCs
+	ProxyEnv         []corev1.EnvVar                        // proxy settings of the transcode container
+	MountTimeout     time.Duration                          // wait on failing volume attach or mount, unlimited if 0
+	OOMBump          float64                                // memory multiplier of the retry after OOMKilled, no retry if 0
+	OOMBumpMax       int64                                  // memory cap of the retry after OOMKilled, uncapped if 0
+	TokenExpiry      int64                                  // expiration seconds of projected service account tokens, cluster default if 0
+	RuntimeClass     *string                                // runtimeClassName of transcode pod, unset if nil
+	PVCBindTimeout   time.Duration                          // wait for PVCs of transcode pod to be bound, no wait if 0
+}
+
+// FetchMetadata fetches and populates a metadata object based on the current environment
+func FetchMetadata(ctx context.Context, cl kubernetes.Interface, name, namespace string) (PmsMetadata, error) {
+	if name == "" {
+		return PmsMetadata{}, fmt.Errorf("%w: pod name is empty", ErrPodNotFound)
+	}
+
+	if namespace == "" {
+		return PmsMetadata{}, fmt.Errorf("%w: namespace is empty", ErrPodNotFound)
+	}
+
+	pod, err := cl.CoreV1().Pods(namespace).Get(ctx, name, v1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return PmsMetadata{}, wrapError(ErrPodNotFound, err)
+	}
+	if err != nil {
+		return PmsMetadata{}, fmt.Errorf("unable to fetch Pod info: %w", err)
+	}
+
+	m := PmsMetadata{
+		Name:      pod.GetName(),
+		Namespace: pod.GetNamespace(),
+		UID:       pod.GetUID(),
+		PodIP:     pod.Status.PodIP,
+		NodeIP:    pod.Status.HostIP,
+	}
+
+	// Namespace annotations are defaults for all PMS pods in the namespace.
+	// Without access to the namespace the pod settings are used alone.
+	var nsSettings map[string]string
+	nsObj, err := cl.CoreV1().Namespaces().Get(ctx, namespace, v1.GetOptions{})
+	switch {
+	case err == nil && nsObj.Status.Phase == corev1.NamespaceTerminating:
+		return PmsMetadata{}, fmt.Errorf("%w: namespace %s is being deleted, transcodes can't be started in it", ErrNamespaceTerminating, namespace)
+	case err == nil:
+		nsSettings = namespaceSettings(nsObj)
+	case !apierrors.IsNotFound(err) && !apierrors.IsForbidden(err):
+		return PmsMetadata{}, fmt.Errorf("unable to fetch Namespace info: %w", err)
+	}
+	a := mergeMaps(nsSettings, podSettings(pod))
+
+	// Get PMS URL, either directly or by looking up the service
+	u, hasURL := a[pmsURL]
+	svc, hasSvc := a[pmsService]
+	switch {
+	case hasURL && hasSvc:
+		return PmsMetadata{}, annotationError(pmsService, "can't be used together with %s", pmsURL)
+	case hasSvc:
+		u, err = getServiceAddr(ctx, cl, m.Namespace, svc, a[pmsServicePort])
+		if err != nil {
+			return PmsMetadata{}, err
+		}
+	case !hasURL:
+		return PmsMetadata{}, annotationError(pmsURL, "unable to determine plex service URL")
+	}
+	m.PmsAddr = u
+
+	m, pod, err = fetchImages(ctx, cl, m, a, pod)
+	if err != nil {
+		return PmsMetadata{}, err
+	}
+
+	// Settings by group, in this order as later groups build on earlier ones,
+	// e.g. the GPU model on the resources and the node selector
+	if m, err = parseVolumes(ctx, cl, m, a, pod); err != nil {
+		return PmsMetadata{}, err
+	}
+	if m, err = parseResources(m, a); err != nil {
+		return PmsMetadata{}, err
+	}
+	if m, err = parseScheduling(m, a, pod); err != nil {
+		return PmsMetadata{}, err
+	}
+	if m, err = parseGPU(m, a); err != nil {
+		return PmsMetadata{}, err
+	}
+	if m, err = parseNetworking(m, a, pod); err != nil {
+		return PmsMetadata{}, err
+	}
+	if m, err = parseLauncher(m, a); err != nil {
+		return PmsMetadata{}, err
+	}
+	if m, err = parseContainers(m, a); err != nil {
+		return PmsMetadata{}, err
+	}
+	if m, err = parsePodMetadata(m, a, pod); err != nil {
+		return PmsMetadata{}, err
+	}
+	if m, err = parseJob(m, a); err != nil {
+		return PmsMetadata{}, err
+	}
+
+	return m, nil
+}
+
+// fetchImages resolves the images of the PMS and the kube-plex init container
+// from pod status, waiting for the PMS image on a cold start. The latest version
+// of the pod is returned with the metadata.
+func fetchImages(ctx context.Context, cl kubernetes.Interface, m PmsMetadata, a map[string]string, pod *corev1.Pod) (PmsMetadata, *corev1.Pod, error) {
+	// Plex media server container image
+	pmsname, err := pmsContainerName(pod, a)
+	if err != nil {
+		return PmsMetadata{}, nil, err
+	}
+	kpname, ok := a[kubePlexContainer]
+	if !ok {
+		kpname = "kube-plex-init"
+	}
+	// images would be resolved from the same container status
+	if kpname == pmsname {
+		return PmsMetadata{}, nil, annotationError(kubePlexContainer, "init container name `%s` is the same as the PMS container name", kpname)
+	}
+	rt, err := parseDurationAnnotation(a, kubePlexResolveWait)
+	if err != nil {
+		return PmsMetadata{}, nil, err
+	}
+	pod, err = waitForContainerImage(ctx, cl, pod, pmsname, rt)
+	if err != nil {
+		return PmsMetadata{}, nil, err
+	}
+	// While the PMS container is being created its status has no image ID,
+	// optionally use the image of the pod spec then
+	pmsFallback, err := parseBoolAnnotation(a, kubePlexPMSFallback)
+	if err != nil {
+		return PmsMetadata{}, nil, err
+	}
+	pmsimage, err := resolveContainerImage(pmsname, pod.Status.ContainerStatuses, pod.Spec.Containers, pmsFallback)
+	if errors.Is(err, ErrImageUnresolved) {
+		return PmsMetadata{}, nil, fmt.Errorf("unable to determine Plex Media server image (wait for it with '%s' or use the pod spec image with '%s'): %w", kubePlexResolveWait, kubePlexPMSFallback, err)
+	}
+	if err != nil {
+		return PmsMetadata{}, nil, fmt.Errorf("unable to determine Plex Media server image (set container name with '%s' annotation): %w", pmsContainer, err)
+	}
+	m.PmsImage = pmsimage
+
+	// Kube-Plex container image, optionally falling back to the image in pod
+	// spec if the runtime didn't report an image ID
+	unresolved, err := parseBoolAnnotation(a, kubePlexUnresolved)
+	if err != nil {
+		return PmsMetadata{}, nil, err
+	}
+	kpimage, err := resolveContainerImage(kpname, pod.Status.InitContainerStatuses, pod.Spec.InitContainers, unresolved)
+	if err != nil {
+		return PmsMetadata{}, nil, fmt.Errorf("unable to determine kube-plex image (set init-container name with '%s' annotation): %w", kubePlexContainer, err)
+	}
+	m.KubePlexImage = kpimage
+
+	// The transcode container can run the kube-plex image, e.g. when it bundles
+	// the transcoder. Only an image pinned by digest runs the same version as
+	// the init container.
+	m.UseInitImage, err = parseBoolAnnotation(a, kubePlexUseInitImage)
+	if err != nil {
+		return PmsMetadata{}, nil, err
+	}
+	if m.UseInitImage && !strings.Contains(kpimage, "@") {
+		return PmsMetadata{}, nil, fmt.Errorf("%w: kube-plex image `%s` has no digest, required by %s", ErrImageUnresolved, kpimage, kubePlexUseInitImage)
+	}
+
+	return m, pod, nil
+}
+
+// parseVolumes sets the volumes of the transcode pod: the mounts copied from
+// PMS, the media PVC, host devices, the scratch space and the output PVC
+func parseVolumes(ctx context.Context, cl kubernetes.Interface, m PmsMetadata, a map[string]string, pod *corev1.Pod) (PmsMetadata, error) {
+	pmsname, err := pmsContainerName(pod, a)
+	if err != nil {
+		return PmsMetadata{}, err
+	}
+
+	// mounts to copy over, either explicitly listed or inferred from the PMS
+	// container mounts
+	if vmj, ok := a[pmsVolumeMounts]; ok {
+		if _, ok := a[pmsMounts]; ok {
+			return PmsMetadata{}, annotationError(pmsVolumeMounts, "can't be used together with %s", pmsMounts)
+		}
+		v, vm, err := parseVolumeMounts(vmj, pod)
+		if err != nil {
+			return PmsMetadata{}, fmt.Errorf("failed to get volumes and mounts: %w", err)
+		}
+		m.VolumeMounts = vm
+		m.Volumes = v
+	} else {
+		if mlist, ok := a[pmsMounts]; !ok {
+			m.Mounts = defaultMounts(pod, pmsname)
+		} else if mlist != "" {
+			m.Mounts = strings.Split(mlist, ",")
+		}
+
+		v, vm, err := getVolumesAndMounts(m.Mounts, pod, pmsname)
+		if err != nil {
+			return PmsMetadata{}, fmt.Errorf("failed to get volumes and mounts: %w", err)
+		}
+		m.VolumeMounts = vm
+		m.Volumes = v
+	}
+
+	// Media PVC found by its labels, e.g. when the claim name includes a hash
+	if sel, ok := a[kubePlexMediaPVC]; ok {
+		v, vm, err := mediaVolume(ctx, cl, m.Namespace, sel, a[kubePlexMediaPath], m.VolumeMounts)
+		if err != nil {
+			return PmsMetadata{}, err
+		}
+		m.Volumes = append(m.Volumes, v)
+		m.VolumeMounts = append(m.VolumeMounts, vm)
+	} else if _, ok := a[kubePlexMediaPath]; ok {
+		return PmsMetadata{}, annotationError(kubePlexMediaPath, "requires %s", kubePlexMediaPVC)
+	}
+
+	// Prefer the nodes holding the data of mounted PVCs, e.g. local PVs. The
+	// nodes are looked up once the DataLocality feature is checked.
+	m.DataLocality, err = parseBoolAnnotation(a, kubePlexDataLocality)
+	if err != nil {
+		return PmsMetadata{}, err
+	}
+
+	// Host devices for hardware transcoding, e.g. /dev/dri for VAAPI
+	if d, ok := a[kubePlexDevices]; ok {
+		m.Devices, err = parseDevices(d)
+		if err != nil {
+			return PmsMetadata{}, annotationError(kubePlexDevices, "%v", err)
+		}
+	}
+
+	// Volume backing the shared scratch space of the transcode pod
+	if csi, ok := a[kubePlexScratchCSI]; ok {
+		v, err := parseCSIVolume(csi)
+		if err != nil {
+			return PmsMetadata{}, annotationError(kubePlexScratchCSI, "%v", err)
+		}
+		m.ScratchVolume = &corev1.VolumeSource{CSI: v}
+	}
+	if c, ok := a[kubePlexScratchClaim]; ok {
+		if m.ScratchVolume != nil {
+			return PmsMetadata{}, annotationError(kubePlexScratchClaim, "can't be used together with %s", kubePlexScratchCSI)
+		}
+		v, err := parseEphemeralVolume(c)
+		if err != nil {
+			return PmsMetadata{}, annotationError(kubePlexScratchClaim, "%v", err)
+		}
+		m.ScratchVolume = &corev1.VolumeSource{Ephemeral: v}
+	}
+	// The storage class of the ephemeral claim, e.g. for transcode tiers
+	// sharing the claim size from namespace defaults
+	if sc, ok := a[kubePlexScratchClass]; ok {
+		if m.ScratchVolume == nil || m.ScratchVolume.Ephemeral == nil {
+			return PmsMetadata{}, annotationError(kubePlexScratchClass, "requires %s for the size of the scratch claim", kubePlexScratchClaim)
+		}
+		if errs := validation.IsDNS1123Subdomain(sc); len(errs) > 0 {
+			return PmsMetadata{}, annotationError(kubePlexScratchClass, "invalid storage class name `%s`", sc)
+		}
+		m.ScratchVolume.Ephemeral.VolumeClaimTemplate.Spec.StorageClassName = &sc
+	}
+
+	// Existing PVC for persisting transcode output
+	mounted := append([]string{"/shared"}, m.Devices...)
+	for _, vm := range m.VolumeMounts {
+		mounted = append(mounted, vm.MountPath)
+	}
+	m.OutputPVC, m.OutputPath, err = parseOutputPVC(a, mounted)
+	if err != nil {
+		return PmsMetadata{}, err
+	}
+
+	return m, nil
+}
+
+// parseResources sets the resources of the transcode container, including the
+// profiles and codec adjustments selected per transcode
+func parseResources(m PmsMetadata, a map[string]string) (PmsMetadata, error) {
+	// resource requests and limits
+	r := a[kubePlexResourceReq]
+	rl, err := parseResourcesJSON(r)
+	if err != nil {
+		return PmsMetadata{}, annotationError(kubePlexResourceReq, "failed to parse resource requests `%s`: %v", r, err)
+	}
+	m.ResourceRequests = rl
+
+	l := a[kubePlexResourceLimit]
+	ll, err := parseResourcesJSON(l)
+	if err != nil {
+		return PmsMetadata{}, annotationError(kubePlexResourceLimit, "failed to parse resource limits `%s`: %v", l, err)
+	}
+	m.ResourceLimits = ll
+
+	// label friendly cpu and memory settings, override the JSON ones
+	for _, q := range []struct {
+		annotation string
+		rl         *corev1.ResourceList
+		name       corev1.ResourceName
+	}{
+		{kubePlexCPURequest, &m.ResourceRequests, corev1.ResourceCPU},
+		{kubePlexMemRequest, &m.ResourceRequests, corev1.ResourceMemory},
+		{kubePlexCPULimit, &m.ResourceLimits, corev1.ResourceCPU},
+		{kubePlexMemLimit, &m.ResourceLimits, corev1.ResourceMemory},
+	} {
+		v, ok := a[q.annotation]
+		if !ok {
+			continue
+		}
+		qty, err := resource.ParseQuantity(v)
+		if err != nil || qty.Sign() <= 0 {
+			return PmsMetadata{}, annotationError(q.annotation, "invalid quantity `%s`", v)
+		}
+		*q.rl = addResources(*q.rl, corev1.ResourceList{q.name: qty})
+	}
+
+	// An OOMKilled transcode is retried once with the memory of the transcode
+	// container scaled up, up to an optional cap
+	if v, ok := a[kubePlexOOMBump]; ok {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f <= 1 {
+			return PmsMetadata{}, annotationError(kubePlexOOMBump, "invalid multiplier `%s`, must be greater than 1", v)
+		}
+		m.OOMBump = f
+	}
+	if v, ok := a[kubePlexOOMBumpMax]; ok {
+		qty, err := resource.ParseQuantity(v)
+		if err != nil || qty.Sign() <= 0 {
+			return PmsMetadata{}, annotationError(kubePlexOOMBumpMax, "invalid quantity `%s`", v)
+		}
+		m.OOMBumpMax = qty.Value()
+	}
+
+	// extended resources, such as network devices
+	if er, ok := a[kubePlexExtraRes]; ok {
+		rl, err := parseExtraResources(er)
+		if err != nil {
+			return PmsMetadata{}, annotationError(kubePlexExtraRes, "%v", err)
+		}
+		m.ExtraResources = rl
+	}
+
+	// pod overhead, for runtimes where the overhead isn't set from the
+	// RuntimeClass by admission
+	if po, ok := a[kubePlexPodOverhead]; ok {
+		rl, err := parsePodOverhead(po)
+		if err != nil {
+			return PmsMetadata{}, annotationError(kubePlexPodOverhead, "%v", err)
+		}
+		m.PodOverhead = rl
+	}
+
+	// resource profiles, selected per transcode
+	if rp, ok := a[kubePlexProfiles]; ok {
+		p, err := parseResourceProfiles(rp)
+		if err != nil {
+			return PmsMetadata{}, annotationError(kubePlexProfiles, "%v", err)
+		}
+		m.ResourceProfiles = p
+	}
+
+	// adjustments by output video codec, on top of the profiles
+	if cr, ok := a[kubePlexCodecRes]; ok {
+		c, err := parseCodecResources(cr, m.ResourceProfiles)
+		if err != nil {
+			return PmsMetadata{}, annotationError(kubePlexCodecRes, "%v", err)
+		}
+		m.CodecResources = c
+	}
+
+	return m, nil
+}
+
+// parseScheduling sets where and how the transcode pod is scheduled
+func parseScheduling(m PmsMetadata, a map[string]string, pod *corev1.Pod) (PmsMetadata, error) {
+	// Transcode pods preferably run elsewhere than PMS, to not starve it
+	avoid, err := parseBoolAnnotation(a, kubePlexAvoidPMSNode)
+	if err != nil {
+		return PmsMetadata{}, err
+	}
+	if avoid && pod.Spec.NodeName == "" {
+		return PmsMetadata{}, annotationError(kubePlexAvoidPMSNode, "node of pod %s is unknown", m.Name)
+	}
+	if avoid {
+		m.AvoidNode = pod.Spec.NodeName
+	}
+
+	// Preemption policy, e.g. Never for transcodes that shouldn't evict lower
+	// priority pods
+	if p, ok := a[kubePlexPreemption]; ok {
+		switch pp := corev1.PreemptionPolicy(p); pp {
+		case corev1.PreemptLowerPriority, corev1.PreemptNever:
+			m.Preemption = &pp
+		default:
+			return PmsMetadata{}, annotationError(kubePlexPreemption, "invalid policy `%s`, expecting %s or %s", p, corev1.PreemptLowerPriority, corev1.PreemptNever)
+		}
+	}
+
+	// RuntimeClass of the transcode pod, optionally the one PMS runs with. The
+	// annotation overrides the inherited class.
+	inheritRC, err := parseBoolAnnotation(a, kubePlexInheritRC)
+	if err != nil {
+		return PmsMetadata{}, err
+	}
+	if rc, ok := a[kubePlexRuntimeClass]; ok {
+		if errs := validation.IsDNS1123Subdomain(rc); len(errs) > 0 {
+			return PmsMetadata{}, annotationError(kubePlexRuntimeClass, "invalid runtime class `%s`: %s", rc, strings.Join(errs, ", "))
+		}
+		m.RuntimeClass = &rc
+	} else if inheritRC && pod.Spec.RuntimeClassName != nil {
+		rc := *pod.Spec.RuntimeClassName
+		m.RuntimeClass = &rc
+	}
+
+	// Node selector, node-selector.kube-plex/ labels are overridden by the
+	// annotation
+	ns, err := parseMapAnnotation(a, kubePlexNodeSelector, nil)
+	if err != nil {
+		return PmsMetadata{}, err
+	}
+	m.NodeSelector = mergeMaps(nodeSelectorLabels(pod.GetLabels()), ns)
+
+	// Node architecture of the transcode pod, must match the PMS image
+	if arch, ok := a[kubePlexArch]; ok {
+		if arch != "" && !supportedArchs[arch] {
+			return PmsMetadata{}, annotationError(kubePlexArch, "unsupported architecture `%s`", arch)
+		}
+		m.Arch = &arch
+	}
+
+	return m, nil
+}
+
+// parseGPU checks the GPU resources set by parseResources and sets the GPU
+// model and render group of the transcode pod. The GPU model is added to the
+// node selector from parseScheduling.
+func parseGPU(m PmsMetadata, a map[string]string) (PmsMetadata, error) {
+	var err error
+	// whole GPUs and MIG partitions can't be mixed in the effective resources
+	if err := checkGPUResources(m.withExtraResources(m.ResourceRequirements())); err != nil {
+		return PmsMetadata{}, annotationError(kubePlexResourceReq, "%v", err)
+	}
+	for n, r := range m.ResourceProfiles {
+		if err := checkGPUResources(m.withExtraResources(r)); err != nil {
+			return PmsMetadata{}, annotationError(kubePlexProfiles, "profile %s: %v", n, err)
+		}
+	}
+
+	// GPU model of the transcode node, matched against the product label set
+	// by GPU feature discovery
+	if gp, ok := a[kubePlexGPUProduct]; ok {
+		if strings.TrimSpace(gp) == "" {
+			return PmsMetadata{}, annotationError(kubePlexGPUProduct, "empty GPU product")
+		}
+		if errs := validation.IsValidLabelValue(gp); len(errs) > 0 {
+			return PmsMetadata{}, annotationError(kubePlexGPUProduct, "invalid GPU product `%s`: %s", gp, strings.Join(errs, ", "))
+		}
+		if !m.requestsGPU() {
+			return PmsMetadata{}, annotationError(kubePlexGPUProduct, "no %s or MIG resources requested", resourceGPU)
+		}
+		m.NodeSelector = mergeMaps(m.NodeSelector, map[string]string{gpuProductLabel: gp})
+	}
+
+	// Group owning the render devices on the node, usually `render` or `video`
+	m.RenderGroup, err = parseIDAnnotation(a, kubePlexRenderGroup)
+	if err != nil {
+		return PmsMetadata{}, err
+	}
+	if m.RenderGroup != nil && *m.RenderGroup == 0 {
+		return PmsMetadata{}, annotationError(kubePlexRenderGroup, "invalid GID `0`, expecting a positive integer")
+	}
+
+	return m, nil
+}
+
+// parseNetworking sets the network of the transcode pod and how the
+// transcoder reaches the codec server and other services
+func parseNetworking(m PmsMetadata, a map[string]string, pod *corev1.Pod) (PmsMetadata, error) {
+	var err error
+	// Host networking for the transcode pod, checked against the codec server
+	// address below
+	m.HostNetwork, err = parseBoolAnnotation(a, kubePlexHostNetwork)
+	if err != nil {
+		return PmsMetadata{}, err
+	}
+
+	// Codec server address for sandboxed runtimes where the default isn't
+	// reachable from the transcoder
+	if cb, ok := a[kubePlexCodecBind]; ok {
+		switch cb {
+		case codecBindPodIP, codecBindLocalhost:
+		case codecBindNodeIP:
+			if m.NodeIP == "" {
+				return PmsMetadata{}, annotationError(kubePlexCodecBind, "node IP of pod %s is unknown", m.Name)
+			}
+		default:
+			return PmsMetadata{}, annotationError(kubePlexCodecBind, "invalid mode `%s`, must be one of %s, %s or %s", cb, codecBindPodIP, codecBindNodeIP, codecBindLocalhost)
+		}
+		m.CodecBindMode = cb
+	}
+	// e.g. an ingress in front of the codec server
+	if cu, ok := a[kubePlexCodecURL]; ok {
+		m.CodecURL, err = parseCodecURL(cu)
+		if err != nil {
+			return PmsMetadata{}, annotationError(kubePlexCodecURL, "%v", err)
+		}
+	}
+	// The codec server listens in the network namespace of PMS, from the host
+	// network it's only known to be reachable when PMS uses the host network
+	// too or the address is chosen explicitly
+	if m.HostNetwork && !pod.Spec.HostNetwork && m.CodecBindMode == "" && m.CodecURL == "" {
+		return PmsMetadata{}, annotationError(kubePlexHostNetwork, "pod %s doesn't use the host network, %s or %s has to be set", m.Name, kubePlexCodecBind, kubePlexCodecURL)
+	}
+
+	// Proxy for the transcoder, e.g. to reach a licensing server
+	for _, p := range []struct {
+		annotation string
+		env        string
+	}{{kubePlexHTTPProxy, "HTTP_PROXY"}, {kubePlexHTTPSProxy, "HTTPS_PROXY"}, {kubePlexNoProxy, "NO_PROXY"}} {
+		v, ok := a[p.annotation]
+		if !ok {
+			continue
+		}
+		if p.annotation == kubePlexNoProxy {
+			v, err = parseNoProxy(v)
+		} else {
+			v, err = parseProxyURL(v)
+		}
+		if err != nil {
+			return PmsMetadata{}, annotationError(p.annotation, "%v", err)
+		}
+		// Both spellings are read by different tools, curl only reads the
+		// lowercase http_proxy
+		m.ProxyEnv = append(m.ProxyEnv, corev1.EnvVar{Name: p.env, Value: v}, corev1.EnvVar{Name: strings.ToLower(p.env), Value: v})
+	}
+
+	// Stable DNS name of the transcode pod with a headless service
+	for _, dn := range []struct {
+		annotation string
+		v          *string
+	}{{kubePlexHostname, &m.Hostname}, {kubePlexSubdomain, &m.Subdomain}} {
+		v, ok := a[dn.annotation]
+		if !ok {
+			continue
+		}
+		if errs := validation.IsDNS1123Label(v); len(errs) > 0 {
+			return PmsMetadata{}, annotationError(dn.annotation, "invalid DNS label `%s`: %s", v, strings.Join(errs, ", "))
+		}
+		*dn.v = v
+	}
+
+	// Service link environment variables, left to the cluster default unless set
+	if _, ok := a[kubePlexServiceLinks]; ok {
+		sl, err := parseBoolAnnotation(a, kubePlexServiceLinks)
+		if err != nil {
+			return PmsMetadata{}, err
+		}
+		m.ServiceLinks = &sl
+	}
+
+	// Additional networks of the transcode pod attached by Multus, e.g. a
+	// dedicated network of GPU nodes
+	if nw, ok := a[kubePlexNetworks]; ok {
+		if err := validateNetworks(nw); err != nil {
+			return PmsMetadata{}, annotationError(kubePlexNetworks, "%v", err)
+		}
+		m.PodAnnotations = mergeMaps(m.PodAnnotations, map[string]string{multusNetworksAnnotation: nw})
+	}
+
+	return m, nil
+}
+
+// parseLauncher sets the flags of the launcher, see LauncherCmd
+func parseLauncher(m PmsMetadata, a map[string]string) (PmsMetadata, error) {
+	var err error
+	// Get debugging status
+	d := a[kubePlexLevel]
+	// TODO: It would be nice to enforce all valid options here
+	m.KubePlexLevel = d
+
+	// path of the transcoder binary in PMS image, defaults to the path kube-plex
+	// was started from
+	if tp, ok := a[kubePlexTranscoder]; ok {
+		if tp == "" {
+			return PmsMetadata{}, annotationError(kubePlexTranscoder, "path is empty")
+		}
+		m.TranscoderPath = tp
+	}
+
+	// sentinel file written by the launcher on completion, must be on the
+	// shared volume to be visible to other containers
+	if cf, ok := a[kubePlexCompleteFile]; ok {
+		if !path.IsAbs(cf) || !strings.HasPrefix(path.Clean(cf), "/shared/") {
+			return PmsMetadata{}, annotationError(kubePlexCompleteFile, "`%s` is not an absolute path under /shared", cf)
+		}
+		m.CompletionFile = path.Clean(cf)
+	}
+
+	// Limits the launcher sets on the transcoder process, within the container
+	// limits
+	if v, ok := a[kubePlexLauncherMem]; ok {
+		qty, err := resource.ParseQuantity(v)
+		if err != nil || qty.Sign() <= 0 {
+			return PmsMetadata{}, annotationError(kubePlexLauncherMem, "invalid quantity `%s`", v)
+		}
+		m.LauncherMem = qty.Value()
+	}
+	m.LauncherCPU, err = parseDurationAnnotation(a, kubePlexLauncherCPU)
+	if err != nil {
+		return PmsMetadata{}, err
+	}
+
+	// Launcher reconnects to PMS, e.g. to survive a PMS rolling update
+	if v, ok := a[kubePlexPMSRetries]; ok {
+		r, err := strconv.Atoi(v)
+		if err != nil || r < 0 {
+			return PmsMetadata{}, annotationError(kubePlexPMSRetries, "invalid number of retries `%s`", v)
+		}
+		m.PmsRetries = r
+	}
+	m.PmsBackoff, err = parseDurationAnnotation(a, kubePlexPMSBackoff)
+	if err != nil {
+		return PmsMetadata{}, err
+	}
+	if v, ok := a[kubePlexPMSJitter]; ok {
+		j, err := strconv.ParseFloat(v, 64)
+		if err != nil || j < 0 || j > 1 {
+			return PmsMetadata{}, annotationError(kubePlexPMSJitter, "invalid jitter `%s`, must be between 0 and 1", v)
+		}
+		m.PmsJitter = j
+	}
+
+	// Launcher wait for the codec server, e.g. while network policies are
+	// being applied to the new pod
+	m.CodecWaitTimeout, err = parseDurationAnnotation(a, kubePlexCodecWait)
+	if err != nil {
+		return PmsMetadata{}, err
+	}
+
+	return m, nil
+}
+
+// parseContainers sets the transcode container and the containers running
+// alongside it
+func parseContainers(m PmsMetadata, a map[string]string) (PmsMetadata, error) {
+	var err error
+	// name of the transcode container
+	if tn, ok := a[transcodeContainer]; ok {
+		if errs := validation.IsDNS1123Label(tn); len(errs) > 0 {
+			return PmsMetadata{}, annotationError(transcodeContainer, "invalid container name `%s`: %s", tn, strings.Join(errs, ", "))
+		}
+		if tn == "kube-plex-init" || tn == sidecarContainer {
+			return PmsMetadata{}, annotationError(transcodeContainer, "container name `%s` is reserved by kube-plex", tn)
+		}
+		m.TranscodeName = tn
+	}
+
+	// security context of the transcode container, e.g. to match NFS exports
+	m.SecurityContext, err = parseSecurityContext(a)
+	if err != nil {
+		return PmsMetadata{}, err
+	}
+	if ap, ok := a[kubePlexAppArmor]; ok {
+		if err := validateAppArmorProfile(ap); err != nil {
+			return PmsMetadata{}, annotationError(kubePlexAppArmor, "%v", err)
+		}
+		key := corev1.AppArmorBetaContainerAnnotationKeyPrefix + m.TranscodeContainerName()
+		m.PodAnnotations = mergeMaps(m.PodAnnotations, map[string]string{key: ap})
+	}
+
+	// Termination message of the transcode container, reported by kube-plex
+	// when the transcode fails
+	if p, ok := a[kubePlexTermPolicy]; ok {
+		switch tp := corev1.TerminationMessagePolicy(p); tp {
+		case corev1.TerminationMessageReadFile, corev1.TerminationMessageFallbackToLogsOnError:
+			m.TermMsgPolicy = tp
+		default:
+			return PmsMetadata{}, annotationError(kubePlexTermPolicy, "invalid policy `%s`, expecting %s or %s", p, corev1.TerminationMessageReadFile, corev1.TerminationMessageFallbackToLogsOnError)
+		}
+	}
+	if p, ok := a[kubePlexTermPath]; ok {
+		if !path.IsAbs(p) || path.Clean(p) != p || p == "/" {
+			return PmsMetadata{}, annotationError(kubePlexTermPath, "invalid path `%s`, must be a clean absolute path", p)
+		}
+		m.TermMsgPath = p
+	}
+
+	// Lifecycle hooks of the transcode container, e.g. a preStop hook for
+	// graceful shutdown
+	if lc, ok := a[kubePlexLifecycle]; ok {
+		m.Lifecycle, err = parseLifecycle(lc)
+		if err != nil {
+			return PmsMetadata{}, annotationError(kubePlexLifecycle, "%v", err)
+		}
+	}
+
+	// stdin and TTY of the transcode container, for attaching to the container
+	// when debugging images
+	m.DebugStdin, err = parseBoolAnnotation(a, kubePlexDebugStdin)
+	if err != nil {
+		return PmsMetadata{}, err
+	}
+	m.DebugTTY, err = parseBoolAnnotation(a, kubePlexDebugTTY)
+	if err != nil {
+		return PmsMetadata{}, err
+	}
+
+	// Process namespace shared by the containers of the transcode pod, e.g. for
+	// sidecars signaling the transcoder
+	if _, ok := a[kubePlexSharePID]; ok {
+		sp, err := parseBoolAnnotation(a, kubePlexSharePID)
+		if err != nil {
+			return PmsMetadata{}, err
+		}
+		m.SharePID = &sp
+	}
+
+	// Sysctls of the transcode pod, e.g. larger socket buffers
+	if j, ok := a[kubePlexSysctls]; ok {
+		m.Sysctls, err = parseSysctls(j)
+		if err != nil {
+			return PmsMetadata{}, annotationError(kubePlexSysctls, "%v", err)
+		}
+	}
+
+	// Sidecar sharing the volumes of the transcoder, e.g. for uploading segments
+	if img, ok := a[kubePlexSidecarImage]; ok {
+		c, err := parseSidecar(img, a[kubePlexSidecarArgs])
+		if err != nil {
+			return PmsMetadata{}, err
+		}
+		m.Sidecar = c
+	} else if _, ok := a[kubePlexSidecarArgs]; ok {
+		return PmsMetadata{}, annotationError(kubePlexSidecarArgs, "requires %s to be set", kubePlexSidecarImage)
+	}
+
+	// Additional containers to run alongside the transcoder
+	if ec, ok := a[kubePlexExtraCont]; ok {
+		c, err := parseExtraContainers(ec, m.TranscodeContainerName())
+		if err != nil {
+			return PmsMetadata{}, annotationError(kubePlexExtraCont, "%v", err)
+		}
+		m.ExtraContainers = c
+	}
+
+	return m, nil
+}
+
+// parsePodMetadata sets the labels and annotations of the transcode pod for
+// cost allocation, disruption budgets, service meshes, the descheduler and
+// backups
+func parsePodMetadata(m PmsMetadata, a map[string]string, pod *corev1.Pod) (PmsMetadata, error) {
+	// Copy selected labels of PMS pod, e.g. for cost allocation
+	m.PodLabels = mergeMaps(m.PodLabels, propagateLabels(pod.GetLabels(), a[kubePlexPropLabels]))
+
+	// Fixed labels, e.g. a team and app taxonomy for cost dashboards
+	if j, ok := a[kubePlexStaticLabels]; ok {
+		sl, err := parseStaticLabels(j)
+		if err != nil {
+			return PmsMetadata{}, annotationError(kubePlexStaticLabels, "%v", err)
+		}
+		m.PodLabels = mergeMaps(m.PodLabels, sl)
+	}
+
+	// Labels selected by a PodDisruptionBudget created by the user
+	pdb, err := parseMapAnnotation(a, kubePlexPDBLabel, nil)
+	if err != nil {
+		return PmsMetadata{}, err
+	}
+	for k, v := range pdb {
+		if errs := validation.IsValidLabelValue(v); len(errs) > 0 {
+			return PmsMetadata{}, annotationError(kubePlexPDBLabel, "invalid value `%s` for label %s: %s", v, k, strings.Join(errs, ", "))
+		}
+	}
+	m.PodLabels = mergeMaps(m.PodLabels, pdb)
+
+	// Exclude the transcode pod from service mesh injection
+	noMesh, err := parseBoolAnnotation(a, kubePlexNoMesh)
+	if err != nil {
+		return PmsMetadata{}, err
+	}
+	if noMesh {
+		ml, err := parseMapAnnotation(a, kubePlexMeshLabels, defaultMeshLabels)
+		if err != nil {
+			return PmsMetadata{}, err
+		}
+		for k, v := range ml {
+			if errs := validation.IsValidLabelValue(v); len(errs) > 0 {
+				return PmsMetadata{}, annotationError(kubePlexMeshLabels, "invalid value for label %s: %s", k, strings.Join(errs, ", "))
+			}
+		}
+		ma, err := parseMapAnnotation(a, kubePlexMeshAnnots, defaultMeshAnnotations)
+		if err != nil {
+			return PmsMetadata{}, err
+		}
+		m.PodLabels = mergeMaps(m.PodLabels, ml)
+		m.PodAnnotations = mergeMaps(m.PodAnnotations, ma)
+	}
+
+	// Protect long transcodes from Descheduler rebalancing
+	noDeschedule, err := parseBoolAnnotation(a, kubePlexNoDeschedule)
+	if err != nil {
+		return PmsMetadata{}, err
+	}
+	if noDeschedule {
+		da, err := parseMapAnnotation(a, kubePlexDeschedAnnots, defaultDeschedulerAnnotations)
+		if err != nil {
+			return PmsMetadata{}, err
+		}
+		m.PodAnnotations = mergeMaps(m.PodAnnotations, da)
+	}
+
+	// Transcode pods are ephemeral, there's nothing worth backing up
+	noBackup, err := parseBoolAnnotation(a, kubePlexNoBackup)
+	if err != nil {
+		return PmsMetadata{}, err
+	}
+	if noBackup {
+		bl, err := parseMapAnnotation(a, kubePlexBackupLabels, defaultBackupLabels)
+		if err != nil {
+			return PmsMetadata{}, err
+		}
+		for k, v := range bl {
+			if errs := validation.IsValidLabelValue(v); len(errs) > 0 {
+				return PmsMetadata{}, annotationError(kubePlexBackupLabels, "invalid value for label %s: %s", k, strings.Join(errs, ", "))
+			}
+		}
+		ba, err := parseMapAnnotation(a, kubePlexBackupAnnots, nil)
+		if err != nil {
+			return PmsMetadata{}, err
+		}
+		m.PodLabels = mergeMaps(m.PodLabels, bl)
+		m.PodAnnotations = mergeMaps(m.PodAnnotations, ba)
+	}
+
+	return m, nil
+}
+
+// parseJob sets how the transcode job is created, waited on and cleaned up
+func parseJob(m PmsMetadata, a map[string]string) (PmsMetadata, error) {
+	var err error
+	// Pod spec fragment for settings without a dedicated annotation, applied as
+	// a strategic merge patch in generateJob
+	if pt, ok := a[kubePlexPodTemplate]; ok {
+		var spec corev1.PodSpec
+		if err := json.Unmarshal([]byte(pt), &spec); err != nil {
+			return PmsMetadata{}, annotationError(kubePlexPodTemplate, "unable to parse pod spec: %v", err)
+		}
+		m.PodTemplate = json.RawMessage(pt)
+	}
+
+	// timeout for watching the transcode job, the watch is restarted once it expires
+	m.WatchTimeout, err = parseDurationAnnotation(a, kubePlexWatchTimeout)
+	if err != nil {
+		return PmsMetadata{}, err
+	}
+
+	// maximum lifetime of the transcode
+	m.MaxLifetime, err = parseDurationAnnotation(a, kubePlexMaxLifetime)
+	if err != nil {
+		return PmsMetadata{}, err
+	}
+
+	// Without the owner reference jobs are only cleaned up by kube-plex and the
+	// job TTL, they survive PMS pod restarts
+	if v, ok := a[kubePlexOwnerRef]; ok {
+		set, err := strconv.ParseBool(v)
+		if err != nil {
+			return PmsMetadata{}, annotationError(kubePlexOwnerRef, "invalid boolean `%s`", v)
+		}
+		m.NoOwnerReference = !set
+	}
+
+	// Fall back to transcoding in the PMS pod when the transcode pod can't be
+	// scheduled in time
+	m.ScheduleTimeout, err = parseDurationAnnotation(a, kubePlexSchedLocal)
+	if err != nil {
+		return PmsMetadata{}, err
+	}
+
+	// Image pull errors of the transcode pod fail the transcode unless waiting
+	// for the kubelet to retry or transcoding locally
+	if v, ok := a[kubePlexPullErrors]; ok {
+		m.PullErrors, err = parsePullErrors(v)
+		if err != nil {
+			return PmsMetadata{}, annotationError(kubePlexPullErrors, "%v", err)
+		}
+	}
+
+	// Volume attach and mount failures are retried by the kubelet, slow CSI
+	// drivers fail a few times before succeeding. Fail the transcode if they
+	// persist for longer than the timeout.
+	m.MountTimeout, err = parseDurationAnnotation(a, kubePlexMountTimeout)
+	if err != nil {
+		return PmsMetadata{}, err
+	}
+
+	// PVCs of the transcode pod can be waited on to be bound before creating
+	// the job, instead of leaving the pod pending
+	m.PVCBindTimeout, err = parseDurationAnnotation(a, kubePlexPVCBind)
+	if err != nil {
+		return PmsMetadata{}, err
+	}
+
+	// Projected service account tokens of the transcode pod expire after the
+	// given duration, within the bounds of the TokenRequest API
+	te, err := parseDurationAnnotation(a, kubePlexTokenExpiry)
+	if err != nil {
+		return PmsMetadata{}, err
+	}
+	if te != 0 && (te < minTokenExpiry || te > maxTokenExpiry) {
+		return PmsMetadata{}, annotationError(kubePlexTokenExpiry, "expiration `%s` out of range, must be between %v and %v", a[kubePlexTokenExpiry], minTokenExpiry, maxTokenExpiry)
+	}
+	m.TokenExpiry = int64(te.Seconds())
+
+	// Transcode locally when the job can't be created due to missing RBAC
+	// permissions, instead of failing the stream
+	m.LocalOnForbidden, err = parseBoolAnnotation(a, kubePlexRBACFallback)
+	if err != nil {
+		return PmsMetadata{}, err
+	}
+
+	// Fail before creating the job when it wouldn't fit in the quota
+	m.QuotaPrecheck, err = parseBoolAnnotation(a, kubePlexQuotaCheck)
+	if err != nil {
+		return PmsMetadata{}, err
+	}
+
+	// Soft limit of transcodes in the namespace, counted from labeled pods
+	if v, ok := a[kubePlexMaxTranscodes]; ok {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return PmsMetadata{}, annotationError(kubePlexMaxTranscodes, "invalid number of transcodes `%s`, expecting a positive integer", v)
+		}
+		m.MaxTranscodes = n
+		m.PodLabels = mergeMaps(m.PodLabels, map[string]string{managedByLabel: managedByValue})
+	}
+
+	// Successful jobs are left for the job TTL to clean up
+	m.KeepSuccessful, err = parseBoolAnnotation(a, kubePlexKeepSuccess)
+	if err != nil {
+		return PmsMetadata{}, err
+	}
+
+	// Trace context for the transcoder, see withTraceEnv
+	m.TraceEnv, err = parseBoolAnnotation(a, kubePlexTraceEnv)
+	if err != nil {
+		return PmsMetadata{}, err
+	}
+
+	return m, nil
+}
+
+// parseExtraContainers parses a JSON list of containers and verifies that the
+// container names are valid and don't collide with the containers kube-plex
+// creates itself.
+//
+// Extra containers share the pod with the transcoder and can mount the same
+// volumes by name, including the `shared` scratch volume. The transcode job is
+// only complete once all of the containers have exited, so the containers must
+// terminate on their own.
+func parseExtraContainers(j, transcodeName string) ([]corev1.Container, error) {
+	var c []corev1.Container
+	d := json.NewDecoder(strings.NewReader(j))
+	d.DisallowUnknownFields()
+	if err := d.Decode(&c); err != nil {
+		return nil, fmt.Errorf("unable to parse containers: %v", err)
+	}
+
+	names := map[string]bool{transcodeName: true, "kube-plex-init": true, sidecarContainer: true}
+	for _, e := range c {
+		if errs := validation.IsDNS1123Label(e.Name); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid container name `%s`: %s", e.Name, strings.Join(errs, ", "))
+		}
+		if names[e.Name] {
+			return nil, fmt.Errorf("container name `%s` is already in use", e.Name)
+		}
+		if e.Image == "" {
+			return nil, fmt.Errorf("container %s has no image", e.Name)
+		}
+		names[e.Name] = true
+	}
+	return c, nil
+}
+
+// parseSidecar returns the sidecar container for an image and a JSON list of
+// arguments. Volumes and environment are filled in when generating the job.
+//
+// The sidecar is a regular container so the job is only complete once both the
+// transcoder and the sidecar have exited, and only successful if both exit
+// with zero. The launcher writes its exit code to the completion file once the
+// transcode is over, the sidecar is expected to finish its work and exit after
+// the file appears.
+func parseSidecar(image, args string) (*corev1.Container, error) {
+	if image == "" {
+		return nil, annotationError(kubePlexSidecarImage, "image is empty")
+	}
+	c := &corev1.Container{Name: sidecarContainer, Image: image}
+	if args != "" {
+		if err := json.Unmarshal([]byte(args), &c.Args); err != nil {
+			return nil, annotationError(kubePlexSidecarArgs, "unable to parse arguments `%s`: %v", args, err)
+		}
+	}
+	return c, nil
+}
+
+// parseSecurityContext returns the security context for the transcode
+// container from annotations, nil if none of the settings are used
+func parseSecurityContext(a map[string]string) (*corev1.SecurityContext, error) {
+	uid, err := parseIDAnnotation(a, kubePlexRunAsUser)
+	if err != nil {
+		return nil, err
+	}
+	gid, err := parseIDAnnotation(a, kubePlexRunAsGroup)
+	if err != nil {
+		return nil, err
+	}
+	var sp *corev1.SeccompProfile
+	if v, ok := a[kubePlexSeccomp]; ok {
+		sp, err = parseSeccompProfile(v)
+		if err != nil {
+			return nil, annotationError(kubePlexSeccomp, "%v", err)
+		}
+	}
+	if uid == nil && gid == nil && sp == nil {
+		return nil, nil
+	}
+	return &corev1.SecurityContext{RunAsUser: uid, RunAsGroup: gid, SeccompProfile: sp}, nil
+}
+
+// parseSeccompProfile parses a seccomp profile: `RuntimeDefault`, `Unconfined`
+// or `Localhost/<path>` with a path relative to the kubelet seccomp directory
+func parseSeccompProfile(v string) (*corev1.SeccompProfile, error) {
+	switch t := corev1.SeccompProfileType(v); t {
+	case corev1.SeccompProfileTypeRuntimeDefault, corev1.SeccompProfileTypeUnconfined:
+		return &corev1.SeccompProfile{Type: t}, nil
+	}
+	prefix := string(corev1.SeccompProfileTypeLocalhost) + "/"
+	if !strings.HasPrefix(v, prefix) {
+		return nil, fmt.Errorf("invalid seccomp profile `%s`, expecting RuntimeDefault, Unconfined or Localhost/<path>", v)
+	}
+	path := strings.TrimPrefix(v, prefix)
+	if path == "" || strings.HasPrefix(path, "/") || strings.Contains(path, "..") {
+		return nil, fmt.Errorf("invalid localhost seccomp profile path `%s`, expecting a relative path", path)
+	}
+	return &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeLocalhost, LocalhostProfile: &path}, nil
+}
+
+// validateAppArmorProfile checks an AppArmor profile: `runtime/default`,
+// `unconfined` or `localhost/<profile>`
+func validateAppArmorProfile(v string) error {
+	switch {
+	case v == corev1.AppArmorBetaProfileRuntimeDefault, v == corev1.AppArmorBetaProfileNameUnconfined:
+		return nil
+	case strings.HasPrefix(v, corev1.AppArmorBetaProfileNamePrefix) && len(v) > len(corev1.AppArmorBetaProfileNamePrefix):
+		return nil
+	}
+	return fmt.Errorf("invalid AppArmor profile `%s`, expecting runtime/default, unconfined or localhost/<profile>", v)
+}
+
+// parseIDAnnotation returns a user or group ID from an annotation, nil if the
+// annotation is missing
+func parseIDAnnotation(a map[string]string, annotation string) (*int64, error) {
+	v, ok := a[annotation]
+	if !ok {
+		return nil, nil
+	}
+	id, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || id < 0 {
+		return nil, annotationError(annotation, "invalid ID `%s`, expecting a non-negative integer", v)
+	}
+	return &id, nil
+}
+
+// parseExtraResources parses a JSON map of resource names to quantities, e.g.
+// `{"vpc.amazonaws.com/efa": "1"}`
+func parseExtraResources(j string) (corev1.ResourceList, error) {
+	var r map[string]string
+	if err := json.Unmarshal([]byte(j), &r); err != nil {
+		return nil, fmt.Errorf("unable to parse resources: %v", err)
+	}
+	rl := corev1.ResourceList{}
+	for n, v := range r {
+		if errs := validation.IsQualifiedName(n); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid resource name `%s`: %s", n, strings.Join(errs, ", "))
+		}
+		q, err := resource.ParseQuantity(v)
+		if err != nil || q.Sign() <= 0 {
+			return nil, fmt.Errorf("invalid quantity `%s` for resource %s", v, n)
+		}
+		rl[corev1.ResourceName(n)] = q
+	}
+	return rl, nil
+}
+
+// parseCodecURL validates an absolute HTTP(S) codec server URL. An empty path
+// is the root of the server.
+func parseCodecURL(s string) (string, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL `%s`: %v", s, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return "", fmt.Errorf("invalid URL `%s`, expecting an absolute http or https URL", s)
+	}
+	if u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+		return "", fmt.Errorf("invalid URL `%s`, query, fragment and user info aren't supported", s)
+	}
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	return u.String(), nil
+}
+
+// parseProxyURL validates a proxy URL, which needs a scheme and a host. User
+// info is allowed for proxy authentication.
+func parseProxyURL(s string) (string, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL `%s`: %v", s, err)
+	}
+	switch {
+	case u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5" || u.Host == "":
+		return "", fmt.Errorf("invalid proxy URL `%s`, expecting an absolute http, https or socks5 URL", u.Redacted())
+	case u.Path != "" && u.Path != "/" || u.RawQuery != "" || u.Fragment != "":
+		return "", fmt.Errorf("invalid proxy URL `%s`, path, query and fragment aren't supported", u.Redacted())
+	}
+	return s, nil
+}
+
+// parseNoProxy validates a comma separated list of hosts, domains and CIDRs
+// bypassing the proxy, returning it without whitespace
+func parseNoProxy(s string) (string, error) {
+	var hosts []string
+	for _, h := range strings.Split(s, ",") {
+		h = strings.TrimSpace(h)
+		if h == "" {
+			continue
+		}
+		if strings.ContainsAny(h, " \t/") && !isCIDR(h) || strings.Contains(h, "://") {
+			return "", fmt.Errorf("invalid no proxy entry `%s`, expecting a host, domain or CIDR", h)
+		}
+		hosts = append(hosts, h)
+	}
+	if len(hosts) == 0 {
+		return "", fmt.Errorf("empty no proxy list")
+	}
+	return strings.Join(hosts, ","), nil
+}
+
+// isCIDR reports whether s is an IP network in CIDR notation
+func isCIDR(s string) bool {
+	_, _, err := net.ParseCIDR(s)
+	return err == nil
+}
+
+// parseSysctls parses a JSON list of sysctls. Unsafe sysctls are accepted, pods
+// using them are rejected by kubelets that don't allow them.
+func parseSysctls(j string) ([]corev1.Sysctl, error) {
+	var sysctls []corev1.Sysctl
+	d := json.NewDecoder(strings.NewReader(j))
+	d.DisallowUnknownFields()
+	if err := d.Decode(&sysctls); err != nil {
+		return nil, fmt.Errorf("unable to parse sysctls: %v", err)
+	}
+	seen := map[string]bool{}
+	for _, s := range sysctls {
+		if len(s.Name) > 253 || !sysctlName.MatchString(s.Name) {
+			return nil, fmt.Errorf("invalid sysctl name `%s`", s.Name)
+		}
+		if seen[s.Name] {
+			return nil, fmt.Errorf("duplicate sysctl `%s`", s.Name)
+		}
+		seen[s.Name] = true
+	}
+	return sysctls, nil
+}
+
+// parseStaticLabels parses a JSON map of pod labels. Labels the job controller
+// sets on its pods can't be overridden.
+func parseStaticLabels(j string) (map[string]string, error) {
+	var l map[string]string
+	d := json.NewDecoder(strings.NewReader(j))
+	if err := d.Decode(&l); err != nil {
+		return nil, fmt.Errorf("unable to parse labels: %v", err)
+	}
+	for k, v := range l {
+		if errs := validation.IsQualifiedName(k); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid label key `%s`: %s", k, strings.Join(errs, ", "))
+		}
+		if k == "job-name" || k == "controller-uid" || strings.HasPrefix(k, "batch.kubernetes.io/") {
+			return nil, fmt.Errorf("label %s is set by the job controller", k)
+		}
+		if errs := validation.IsValidLabelValue(v); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid value `%s` for label %s: %s", v, k, strings.Join(errs, ", "))
+		}
+	}
+	return l, nil
 }
 
-// FetchMetadata fetches and populates a metadata object based on the current environment
-func FetchMetadata(ctx context.Context, cl kubernetes.Interface, name, namespace string) (PmsMetadata, error) {
-	if name == "" {
-		return PmsMetadata{}, fmt.Errorf("pod name is empty")
+// parseDevices parses a comma separated list of host device paths, which must
+// be under /dev
+func parseDevices(list string) ([]string, error) {
+	var devices []string
+	seen := map[string]bool{}
+	for _, d := range strings.Split(list, ",") {
+		d = strings.TrimSpace(d)
+		if d == "" {
+			continue
+		}
+		if path.Clean(d) != d || !strings.HasPrefix(d, "/dev/") {
+			return nil, fmt.Errorf("invalid device path `%s`, must be a clean path under /dev", d)
+		}
+		if seen[d] {
+			return nil, fmt.Errorf("duplicate device path `%s`", d)
+		}
+		seen[d] = true
+		devices = append(devices, d)
 	}
+	return devices, nil
+}
 
-	if namespace == "" {
-		return PmsMetadata{}, fmt.Errorf("namespace is empty")
+// DeviceVolumes returns host path volumes and mounts for the devices of the
+// transcode container, mounted at the same path as on the host
+func (p PmsMetadata) DeviceVolumes() ([]corev1.Volume, []corev1.VolumeMount) {
+	var v []corev1.Volume
+	var vm []corev1.VolumeMount
+	for i, d := range p.Devices {
+		name := fmt.Sprintf("kube-plex-device-%d", i)
+		v = append(v, corev1.Volume{Name: name, VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: d}}})
+		vm = append(vm, corev1.VolumeMount{Name: name, MountPath: d})
 	}
+	return v, vm
+}
 
-	pod, err := cl.CoreV1().Pods(namespace).Get(ctx, name, v1.GetOptions{})
+// parseOutputPVC returns the claim name and mount path of the output PVC from
+// annotations. The path can't be one of the mounted paths of the transcode pod.
+func parseOutputPVC(a map[string]string, mounted []string) (string, string, error) {
+	claim, ok := a[kubePlexOutputPVC]
+	op, hasPath := a[kubePlexOutputPath]
+	if !ok {
+		if hasPath {
+			return "", "", annotationError(kubePlexOutputPath, "requires %s", kubePlexOutputPVC)
+		}
+		return "", "", nil
+	}
+	if errs := validation.IsDNS1123Subdomain(claim); len(errs) > 0 {
+		return "", "", annotationError(kubePlexOutputPVC, "invalid claim name `%s`: %s", claim, strings.Join(errs, ", "))
+	}
+	if !hasPath {
+		op = defaultOutputPath
+	}
+	if !path.IsAbs(op) || path.Clean(op) != op || op == "/" {
+		return "", "", annotationError(kubePlexOutputPath, "invalid path `%s`, must be a clean absolute path", op)
+	}
+	for _, m := range mounted {
+		if m == op {
+			return "", "", annotationError(kubePlexOutputPath, "path `%s` is already mounted in transcode pod", op)
+		}
+	}
+	return claim, op, nil
+}
+
+// OutputVolume returns the volume and mount of the output PVC, nil if no
+// output PVC is set
+func (p PmsMetadata) OutputVolume() ([]corev1.Volume, []corev1.VolumeMount) {
+	if p.OutputPVC == "" {
+		return nil, nil
+	}
+	v := corev1.Volume{Name: "kube-plex-output", VolumeSource: corev1.VolumeSource{
+		PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: p.OutputPVC},
+	}}
+	return []corev1.Volume{v}, []corev1.VolumeMount{{Name: v.Name, MountPath: p.OutputPath}}
+}
+
+// mediaVolume returns the volume and mount of the single PVC in namespace
+// matching the label selector sel. The claim is mounted at mp, defaultMediaPath
+// if empty, which must not be in mounts already.
+func mediaVolume(ctx context.Context, cl kubernetes.Interface, namespace, sel, mp string, mounts []corev1.VolumeMount) (corev1.Volume, corev1.VolumeMount, error) {
+	if _, err := labels.Parse(sel); err != nil || strings.TrimSpace(sel) == "" {
+		return corev1.Volume{}, corev1.VolumeMount{}, annotationError(kubePlexMediaPVC, "invalid label selector `%s`", sel)
+	}
+	if mp == "" {
+		mp = defaultMediaPath
+	}
+	if !path.IsAbs(mp) || path.Clean(mp) != mp || mp == "/" || mp == "/shared" || strings.HasPrefix(mp, "/shared/") {
+		return corev1.Volume{}, corev1.VolumeMount{}, annotationError(kubePlexMediaPath, "invalid path `%s`, must be a clean absolute path", mp)
+	}
+	for _, m := range mounts {
+		if m.MountPath == mp {
+			return corev1.Volume{}, corev1.VolumeMount{}, annotationError(kubePlexMediaPath, "path `%s` is already mounted in transcode pod", mp)
+		}
+	}
+
+	l, err := cl.CoreV1().PersistentVolumeClaims(namespace).List(ctx, v1.ListOptions{LabelSelector: sel})
 	if err != nil {
-		return PmsMetadata{}, fmt.Errorf("unable to fetch Pod info: %v", err)
+		return corev1.Volume{}, corev1.VolumeMount{}, fmt.Errorf("unable to list PVCs in namespace %s: %w", namespace, err)
+	}
+	switch len(l.Items) {
+	case 0:
+		return corev1.Volume{}, corev1.VolumeMount{}, fmt.Errorf("%w: no PVC in namespace %s matches `%s`", ErrVolumeMissing, namespace, sel)
+	case 1:
+	default:
+		names := make([]string, 0, len(l.Items))
+		for _, c := range l.Items {
+			names = append(names, c.Name)
+		}
+		sort.Strings(names)
+		return corev1.Volume{}, corev1.VolumeMount{}, annotationError(kubePlexMediaPVC, "selector `%s` matches multiple PVCs: %s", sel, strings.Join(names, ", "))
 	}
 
-	m := PmsMetadata{
-		Name:      pod.GetName(),
-		Namespace: pod.GetNamespace(),
-		UID:       pod.GetUID(),
-		PodIP:     pod.Status.PodIP,
+	v := corev1.Volume{Name: "kube-plex-media", VolumeSource: corev1.VolumeSource{
+		PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: l.Items[0].Name},
+	}}
+	return v, corev1.VolumeMount{Name: v.Name, MountPath: mp}, nil
+}
+
+// selectedNodeAnnotation is set on PVCs by the scheduler when provisioning is
+// delayed until the first pod is scheduled, naming the node of that pod
+const selectedNodeAnnotation = "volume.kubernetes.io/selected-node"
+
+// dataLocality returns node selector terms for the nodes holding the data of
+// the PVCs in volumes: the node the PVC was provisioned for, or the node
+// affinity of its bound PV. PVCs and PVs that don't exist or can't be read are
+// skipped, as the locality is only a preference.
+func dataLocality(ctx context.Context, cl kubernetes.Interface, namespace string, volumes []corev1.Volume) ([]corev1.NodeSelectorTerm, error) {
+	skip := func(err error) bool { return apierrors.IsNotFound(err) || apierrors.IsForbidden(err) }
+	var terms []corev1.NodeSelectorTerm
+	for _, v := range volumes {
+		if v.PersistentVolumeClaim == nil {
+			continue
+		}
+		name := v.PersistentVolumeClaim.ClaimName
+		pvc, err := cl.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, name, v1.GetOptions{})
+		if skip(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to fetch PVC %s/%s: %w", namespace, name, err)
+		}
+		if n := pvc.Annotations[selectedNodeAnnotation]; n != "" {
+			terms = append(terms, corev1.NodeSelectorTerm{MatchFields: []corev1.NodeSelectorRequirement{{
+				Key:      "metadata.name",
+				Operator: corev1.NodeSelectorOpIn,
+				Values:   []string{n},
+			}}})
+			continue
+		}
+		if pvc.Spec.VolumeName == "" {
+			continue
+		}
+		pv, err := cl.CoreV1().PersistentVolumes().Get(ctx, pvc.Spec.VolumeName, v1.GetOptions{})
+		if skip(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to fetch PV %s: %w", pvc.Spec.VolumeName, err)
+		}
+		if na := pv.Spec.NodeAffinity; na != nil && na.Required != nil {
+			terms = append(terms, na.Required.NodeSelectorTerms...)
+		}
 	}
+	return terms, nil
+}
 
-	// Get PMS URL
-	a := pod.GetAnnotations()
-	u, ok := a[pmsURL]
-	if !ok {
-		return PmsMetadata{}, fmt.Errorf("unable to determine plex service URL")
+// parseLifecycle parses a JSON container lifecycle, each hook must have exactly
+// one handler
+func parseLifecycle(j string) (*corev1.Lifecycle, error) {
+	var lc corev1.Lifecycle
+	d := json.NewDecoder(strings.NewReader(j))
+	d.DisallowUnknownFields()
+	if err := d.Decode(&lc); err != nil {
+		return nil, fmt.Errorf("unable to parse lifecycle: %v", err)
 	}
-	m.PmsAddr = u
+	for name, h := range map[string]*corev1.Handler{"postStart": lc.PostStart, "preStop": lc.PreStop} {
+		if h == nil {
+			continue
+		}
+		n := 0
+		if h.Exec != nil {
+			if len(h.Exec.Command) == 0 {
+				return nil, fmt.Errorf("%s exec handler has no command", name)
+			}
+			n++
+		}
+		if h.HTTPGet != nil {
+			n++
+		}
+		if h.TCPSocket != nil {
+			n++
+		}
+		if n != 1 {
+			return nil, fmt.Errorf("%s hook must have exactly one handler, found %d", name, n)
+		}
+	}
+	return &lc, nil
+}
 
-	// Get debugging status
-	d := a[kubePlexLevel]
-	// TODO: It would be nice to enforce all valid options here
-	m.KubePlexLevel = d
+// parsePodOverhead parses a JSON map of resource names to quantities, e.g.
+// `{"cpu": "250m", "memory": "120Mi"}`. Unlike container resources, overhead
+// can be zero.
+func parsePodOverhead(j string) (corev1.ResourceList, error) {
+	var rl corev1.ResourceList
+	if err := json.Unmarshal([]byte(j), &rl); err != nil {
+		return nil, fmt.Errorf("unable to parse pod overhead: %v", err)
+	}
+	for n, q := range rl {
+		if errs := validation.IsQualifiedName(string(n)); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid resource name `%s`: %s", n, strings.Join(errs, ", "))
+		}
+		if q.Sign() < 0 {
+			return nil, fmt.Errorf("negative quantity `%s` for resource %s", q.String(), n)
+		}
+	}
+	return rl, nil
+}
 
-	// Plex media server container image
-	pmsimage, pmsname, err := getContainerImage(pmsContainer, "plex", pod, pod.Status.ContainerStatuses)
-	if err != nil {
-		return PmsMetadata{}, fmt.Errorf("unable to determine Plex Media server image (set container name with '%s' annotation): %v", pmsContainer, err)
+// parseCSIVolume parses a JSON CSI ephemeral volume source, e.g.
+// `{"driver": "local.csi.example.com", "volumeAttributes": {"size": "10Gi"}}`
+func parseCSIVolume(j string) (*corev1.CSIVolumeSource, error) {
+	var v corev1.CSIVolumeSource
+	d := json.NewDecoder(strings.NewReader(j))
+	d.DisallowUnknownFields()
+	if err := d.Decode(&v); err != nil {
+		return nil, fmt.Errorf("unable to parse CSI volume: %v", err)
 	}
-	m.PmsImage = pmsimage
+	if errs := validation.IsDNS1123Subdomain(v.Driver); len(errs) > 0 || len(v.Driver) > 63 {
+		return nil, fmt.Errorf("invalid CSI driver name `%s`", v.Driver)
+	}
+	return &v, nil
+}
+
+// parseEphemeralVolume parses a generic ephemeral volume, either as a JSON
+// ephemeral volume source or as `<storage class>:<size>`. An empty storage
+// class uses the default storage class of the cluster. Claims without access
+// modes are ReadWriteOnce.
+func parseEphemeralVolume(s string) (*corev1.EphemeralVolumeSource, error) {
+	var v corev1.EphemeralVolumeSource
+	if strings.HasPrefix(strings.TrimSpace(s), "{") {
+		d := json.NewDecoder(strings.NewReader(s))
+		d.DisallowUnknownFields()
+		if err := d.Decode(&v); err != nil {
+			return nil, fmt.Errorf("unable to parse ephemeral volume: %v", err)
+		}
+		if v.VolumeClaimTemplate == nil {
+			return nil, fmt.Errorf("ephemeral volume has no volumeClaimTemplate")
+		}
+	} else {
+		parts := strings.SplitN(s, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("expected `<storage class>:<size>`, got `%s`", s)
+		}
+		size, err := resource.ParseQuantity(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid size `%s`: %v", parts[1], err)
+		}
+		v.VolumeClaimTemplate = &corev1.PersistentVolumeClaimTemplate{Spec: corev1.PersistentVolumeClaimSpec{
+			Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceStorage: size}},
+		}}
+		if sc := strings.TrimSpace(parts[0]); sc != "" {
+			v.VolumeClaimTemplate.Spec.StorageClassName = &sc
+		}
+	}
+
+	spec := &v.VolumeClaimTemplate.Spec
+	if sc := spec.StorageClassName; sc != nil {
+		if errs := validation.IsDNS1123Subdomain(*sc); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid storage class name `%s`", *sc)
+		}
+	}
+	size, ok := spec.Resources.Requests[corev1.ResourceStorage]
+	if !ok || size.Sign() <= 0 {
+		return nil, fmt.Errorf("ephemeral volume needs a positive storage request")
+	}
+	if len(spec.AccessModes) == 0 {
+		spec.AccessModes = []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce}
+	}
+	return &v, nil
+}
 
-	// Kube-Plex container image
-	kpimage, _, err := getContainerImage(kubePlexContainer, "kube-plex-init", pod, pod.Status.InitContainerStatuses)
+// checkScratchStorageClass returns ErrVolumeMissing if the storage class of
+// the ephemeral scratch claim of v doesn't exist. Scratch volumes without a
+// storage class use the cluster default and aren't checked.
+func checkScratchStorageClass(ctx context.Context, cl kubernetes.Interface, v corev1.VolumeSource) error {
+	if v.Ephemeral == nil || v.Ephemeral.VolumeClaimTemplate == nil || v.Ephemeral.VolumeClaimTemplate.Spec.StorageClassName == nil {
+		return nil
+	}
+	sc := *v.Ephemeral.VolumeClaimTemplate.Spec.StorageClassName
+	_, err := cl.StorageV1().StorageClasses().Get(ctx, sc, v1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return fmt.Errorf("%w: storage class %s of the scratch claim doesn't exist", ErrVolumeMissing, sc)
+	}
 	if err != nil {
-		return PmsMetadata{}, fmt.Errorf("unable to determine kube-plex image (set init-container name with '%s' annotation): %v", kubePlexContainer, err)
+		return fmt.Errorf("unable to get storage class %s: %w", sc, err)
 	}
-	m.KubePlexImage = kpimage
+	return nil
+}
+
+// validateNetworks checks a Multus network selection, either a comma separated
+// list of `[<namespace>/]<network>[@<interface>]` or a JSON list of network
+// selection objects. The networks themselves are resolved by Multus.
+func validateNetworks(s string) error {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return fmt.Errorf("no networks")
+	}
+	if strings.HasPrefix(s, "[") {
+		var nets []struct {
+			Name string `json:"name"`
+		}
+		if err := json.Unmarshal([]byte(s), &nets); err != nil {
+			return fmt.Errorf("unable to parse network selection: %v", err)
+		}
+		if len(nets) == 0 {
+			return fmt.Errorf("no networks")
+		}
+		for _, n := range nets {
+			if n.Name == "" {
+				return fmt.Errorf("network selection without name")
+			}
+		}
+		return nil
+	}
+	for _, n := range strings.Split(s, ",") {
+		if strings.TrimSpace(n) == "" {
+			return fmt.Errorf("empty network name in `%s`", s)
+		}
+	}
+	return nil
+}
+
+// podSettings returns the kube-plex settings of the PMS pod. Pod labels with
+// the `kube-plex/` prefix are used for settings that aren't set with an
+// annotation.
+func podSettings(pod *corev1.Pod) map[string]string {
+	s := map[string]string{}
+	for k, v := range pod.GetLabels() {
+		if strings.HasPrefix(k, settingPrefix) {
+			s[k] = v
+		}
+	}
+	return mergeMaps(s, pod.GetAnnotations())
+}
+
+// namespaceSettings returns the kube-plex settings from the annotations of the
+// PMS namespace, other annotations of the namespace are left out
+func namespaceSettings(ns *corev1.Namespace) map[string]string {
+	s := map[string]string{}
+	for k, v := range ns.GetAnnotations() {
+		if strings.HasPrefix(k, settingPrefix) {
+			s[k] = v
+		}
+	}
+	return s
+}
 
-	// mounts to copy over
-	mlist, ok := a[pmsMounts]
+// nodeSelectorLabels returns a node selector from the pod labels with the
+// `node-selector.kube-plex/` prefix, e.g. `node-selector.kube-plex/pool: transcode`
+// selects nodes labeled `pool: transcode`
+func nodeSelectorLabels(labels map[string]string) map[string]string {
+	var ns map[string]string
+	for k, v := range labels {
+		if n := strings.TrimPrefix(k, nodeSelectorPrefix); n != k {
+			if ns == nil {
+				ns = map[string]string{}
+			}
+			ns[n] = v
+		}
+	}
+	return ns
+}
+
+// parseMapAnnotation returns a map from a comma separated list of `key=value`
+// pairs in an annotation. Keys need to be valid label or annotation keys.
+// Missing annotation returns the default.
+func parseMapAnnotation(a map[string]string, annotation string, def map[string]string) (map[string]string, error) {
+	v, ok := a[annotation]
 	if !ok {
-		// default value, matches the old behaviour
-		mlist = "/transcode,/data"
+		return def, nil
 	}
-	if mlist != "" {
-		m.Mounts = strings.Split(mlist, ",")
+	m := map[string]string{}
+	for _, p := range strings.Split(v, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		kv := strings.SplitN(p, "=", 2)
+		if len(kv) != 2 {
+			return nil, annotationError(annotation, "invalid key value pair `%s`", p)
+		}
+		k := strings.TrimSpace(kv[0])
+		if errs := validation.IsQualifiedName(k); len(errs) > 0 {
+			return nil, annotationError(annotation, "invalid key `%s`: %s", k, strings.Join(errs, ", "))
+		}
+		m[k] = strings.TrimSpace(kv[1])
 	}
+	return m, nil
+}
 
-	v, vm, err := getVolumesAndMounts(m.Mounts, pod, pmsname)
-	if err != nil {
-		return PmsMetadata{}, fmt.Errorf("failed to get volumes and mounts: %v", err)
+// propagateLabels returns the labels in a comma separated list of keys that are
+// set in labels, keys missing from labels are skipped
+func propagateLabels(labels map[string]string, keys string) map[string]string {
+	out := map[string]string{}
+	for _, k := range strings.Split(keys, ",") {
+		if v, ok := labels[strings.TrimSpace(k)]; ok {
+			out[strings.TrimSpace(k)] = v
+		}
 	}
-	m.VolumeMounts = vm
-	m.Volumes = v
+	return out
+}
 
-	// resource requests and limits
-	r := a[kubePlexResourceReq]
-	rl, err := parseResourcesJSON(r)
+// mergeMaps returns a copy of dst with the values from src added
+func mergeMaps(dst, src map[string]string) map[string]string {
+	if len(src) == 0 {
+		return dst
+	}
+	out := make(map[string]string, len(dst)+len(src))
+	for k, v := range dst {
+		out[k] = v
+	}
+	for k, v := range src {
+		out[k] = v
+	}
+	return out
+}
+
+// parseDurationAnnotation returns the duration value of an annotation, missing
+// annotation is treated as zero duration
+func parseDurationAnnotation(a map[string]string, annotation string) (time.Duration, error) {
+	v, ok := a[annotation]
+	if !ok {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return 0, annotationError(annotation, "invalid duration `%s`", v)
+	}
+	return d, nil
+}
+
+// parseBoolAnnotation returns the boolean value of an annotation, missing
+// annotation is treated as false
+func parseBoolAnnotation(a map[string]string, annotation string) (bool, error) {
+	v, ok := a[annotation]
+	if !ok {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(v)
 	if err != nil {
-		return PmsMetadata{}, fmt.Errorf("failed to parse resource requests `%s`: %v", r, err)
+		return false, annotationError(annotation, "invalid boolean `%s`", v)
 	}
-	m.ResourceRequests = rl
+	return b, nil
+}
 
-	l := a[kubePlexResourceLimit]
-	ll, err := parseResourcesJSON(l)
+// getServiceAddr resolves a service reference (`name` or `namespace/name`) to
+// the cluster IP and port of the service. Services with multiple ports need to
+// have the port selected by name.
+func getServiceAddr(ctx context.Context, cl kubernetes.Interface, namespace, ref, port string) (string, error) {
+	name := ref
+	if i := strings.Index(ref, "/"); i >= 0 {
+		namespace, name = ref[:i], ref[i+1:]
+	}
+	if namespace == "" || name == "" {
+		return "", annotationError(pmsService, "invalid service reference `%s`", ref)
+	}
+
+	svc, err := cl.CoreV1().Services(namespace).Get(ctx, name, v1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return "", wrapError(ErrServiceNotFound, err)
+	}
 	if err != nil {
-		return PmsMetadata{}, fmt.Errorf("failed to parse resource limits `%s`: %v", l, err)
+		return "", fmt.Errorf("unable to fetch service %s/%s: %w", namespace, name, err)
 	}
-	m.ResourceLimits = ll
 
-	return m, nil
+	ip := svc.Spec.ClusterIP
+	if ip == "" || ip == corev1.ClusterIPNone {
+		return "", fmt.Errorf("%w: service %s/%s has no cluster IP", ErrServiceNotFound, namespace, name)
+	}
+
+	ports := svc.Spec.Ports
+	switch {
+	case port != "":
+		for _, p := range ports {
+			if p.Name == port {
+				return net.JoinHostPort(ip, strconv.Itoa(int(p.Port))), nil
+			}
+		}
+		return "", annotationError(pmsServicePort, "service %s/%s has no port named `%s`", namespace, name, port)
+	case len(ports) == 1:
+		return net.JoinHostPort(ip, strconv.Itoa(int(ports[0].Port))), nil
+	case len(ports) == 0:
+		return "", fmt.Errorf("%w: service %s/%s has no ports", ErrServiceNotFound, namespace, name)
+	}
+	return "", annotationError(pmsServicePort, "service %s/%s has multiple ports, select one by name", namespace, name)
 }
 
 // ResourceRequirements creates a container resource requirements object by combining limits and requests
@@ -133,23 +1983,220 @@ func (p PmsMetadata) ResourceRequirements() corev1.ResourceRequirements {
 	}
 }
 
-// getContainerImage from pod status based on the annotation given
-func getContainerImage(annotation, defname string, pod *corev1.Pod, status []corev1.ContainerStatus) (string, string, error) {
-	a := pod.GetAnnotations()
-	name, ok := a[annotation]
-	if !ok {
-		name = defname
+// ScratchVolumeSource returns the volume source for the shared scratch volume,
+// an emptyDir unless another source is configured
+func (p PmsMetadata) ScratchVolumeSource() corev1.VolumeSource {
+	if p.ScratchVolume != nil {
+		return *p.ScratchVolume
+	}
+	return corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}
+}
+
+// TranscodeContainerName returns the name used for the transcode container
+func (p PmsMetadata) TranscodeContainerName() string {
+	if p.TranscodeName != "" {
+		return p.TranscodeName
+	}
+	return defaultTranscodeContainer
+}
+
+// pmsContainerName returns the name of the Plex Media Server container, chosen
+// either by name or by index in the pod spec with settings a
+func pmsContainerName(pod *corev1.Pod, a map[string]string) (string, error) {
+	name, hasName := a[pmsContainer]
+	idx, hasIdx := a[pmsContainerIndex]
+	switch {
+	case hasName && hasIdx:
+		return "", annotationError(pmsContainerIndex, "can't be used together with %s", pmsContainer)
+	case hasIdx:
+		i, err := strconv.Atoi(idx)
+		if err != nil {
+			return "", annotationError(pmsContainerIndex, "invalid index `%s`", idx)
+		}
+		if i < 0 || i >= len(pod.Spec.Containers) {
+			return "", annotationError(pmsContainerIndex, "index %d out of range, pod has %d containers", i, len(pod.Spec.Containers))
+		}
+		return pod.Spec.Containers[i].Name, nil
+	case hasName:
+		return name, nil
+	}
+	return "plex", nil
+}
+
+// waitForContainerImage re-fetches the pod until the image ID of the named
+// container is available in pod status or the timeout expires. The container
+// status may be missing on a cold start while the pod is still starting up. The
+// latest version of the pod is returned.
+func waitForContainerImage(ctx context.Context, cl kubernetes.Interface, pod *corev1.Pod, name string, timeout time.Duration) (*corev1.Pod, error) {
+	resolved := func(p *corev1.Pod) bool {
+		id, err := getContainerImage(name, p.Status.ContainerStatuses)
+		return err == nil && id != ""
+	}
+	if timeout == 0 || resolved(pod) {
+		return pod, nil
 	}
+
+	deadline := clk.NewTimer(timeout)
+	defer deadline.Stop()
+	t := clk.NewTimer(imageResolveInterval)
+	defer t.Stop()
+	for {
+		// on timeout image resolution reports the error
+		select {
+		case <-ctx.Done():
+			return pod, nil
+		case <-deadline.C():
+			return pod, nil
+		case <-t.C():
+			t.Reset(imageResolveInterval)
+		}
+		p, err := cl.CoreV1().Pods(pod.Namespace).Get(ctx, pod.Name, v1.GetOptions{})
+		if ctx.Err() != nil {
+			return pod, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to fetch Pod info: %w", err)
+		}
+		pod = p
+		if resolved(pod) {
+			return pod, nil
+		}
+	}
+}
+
+// getContainerImage returns the image ID of the named container from pod
+// status. It's empty while the runtime hasn't reported the image ID.
+func getContainerImage(name string, status []corev1.ContainerStatus) (string, error) {
 	for _, c := range status {
-		if c.Name == name {
-			imageID := c.ImageID
-			if strings.HasPrefix(imageID, "docker-pullable://") {
-				imageID = imageID[18:]
+		if c.Name != name {
+			continue
+		}
+		imageID := c.ImageID
+		if strings.HasPrefix(imageID, "docker-pullable://") {
+			imageID = imageID[18:]
+		}
+		return imageID, nil
+	}
+	return "", fmt.Errorf("%w: no containers found by name %s", ErrContainerMissing, name)
+}
+
+// resolveContainerImage returns the image of the named container like
+// getContainerImage, but requires the image ID to be resolved. If it isn't and
+// fallback is set, image is read from spec.
+func resolveContainerImage(name string, status []corev1.ContainerStatus, spec []corev1.Container, fallback bool) (string, error) {
+	imageID, err := getContainerImage(name, status)
+	if err != nil || imageID != "" {
+		return imageID, err
+	}
+	if fallback {
+		for _, sc := range spec {
+			if sc.Name == name && sc.Image != "" {
+				return sc.Image, nil
 			}
-			return imageID, name, nil
 		}
 	}
-	return "", "", fmt.Errorf("no containers found by name %s", name)
+	for _, c := range status {
+		if w := c.State.Waiting; c.Name == name && w != nil && w.Reason != "" {
+			return "", fmt.Errorf("%w: image ID of container %s is not available, container is %s", ErrImageUnresolved, name, w.Reason)
+		}
+	}
+	return "", fmt.Errorf("%w: image ID of container %s is not available", ErrImageUnresolved, name)
+}
+
+// parseVolumeMounts parses an explicit JSON list of volume mounts for the
+// transcode container and returns the pod volumes they reference. The shared
+// scratch volume is always mounted by kube-plex and can't be overridden.
+func parseVolumeMounts(j string, pod *corev1.Pod) ([]corev1.Volume, []corev1.VolumeMount, error) {
+	var vm []corev1.VolumeMount
+	d := json.NewDecoder(strings.NewReader(j))
+	d.DisallowUnknownFields()
+	if err := d.Decode(&vm); err != nil {
+		return nil, nil, annotationError(pmsVolumeMounts, "unable to parse volume mounts: %v", err)
+	}
+
+	volumes := map[string]corev1.Volume{}
+	for _, v := range pod.Spec.Volumes {
+		volumes[v.Name] = v
+	}
+
+	paths := map[string]bool{"/shared": true}
+	vtmp := map[string]corev1.Volume{}
+	for _, m := range vm {
+		if m.Name == "" || m.Name == "shared" {
+			return nil, nil, annotationError(pmsVolumeMounts, "invalid volume name `%s`", m.Name)
+		}
+		mp := path.Clean(m.MountPath)
+		if !path.IsAbs(mp) || paths[mp] || strings.HasPrefix(mp, "/shared/") {
+			return nil, nil, annotationError(pmsVolumeMounts, "invalid or duplicate mount path `%s`", m.MountPath)
+		}
+		paths[mp] = true
+
+		v, ok := volumes[m.Name]
+		if !ok {
+			return nil, nil, fmt.Errorf("%w: no volume definition found for volume '%s'", ErrVolumeMissing, m.Name)
+		}
+		vtmp[m.Name] = v
+	}
+
+	// collect the volumes in a predictable order, like getVolumesAndMounts
+	vkeys := make([]string, 0, len(vtmp))
+	for k := range vtmp {
+		vkeys = append(vkeys, k)
+	}
+	sort.Strings(vkeys)
+
+	var v []corev1.Volume
+	for _, k := range vkeys {
+		v = append(v, vtmp[k])
+	}
+	return v, vm, nil
+}
+
+// defaultMountVolumes are the mounts copied to transcode pods by default, with
+// the conventional volume names used to find them when mounted elsewhere
+var defaultMountVolumes = []struct{ path, volume string }{
+	{"/transcode", "transcode"},
+	{"/data", "data"},
+}
+
+// defaultMounts returns the default mount paths for the PMS container. A
+// default path is used when the container mounts a volume there, otherwise the
+// mount path of the conventionally named volume is used. Paths that can't be
+// resolved are returned as is and fail in getVolumesAndMounts.
+func defaultMounts(pod *corev1.Pod, name string) []string {
+	var mounts []corev1.VolumeMount
+	for _, c := range pod.Spec.Containers {
+		if c.Name == name {
+			mounts = c.VolumeMounts
+		}
+	}
+
+	var dirs []string
+	seen := map[string]bool{}
+	for _, d := range defaultMountVolumes {
+		p := resolveMountPath(mounts, d.path, d.volume)
+		if !seen[p] {
+			seen[p] = true
+			dirs = append(dirs, p)
+		}
+	}
+	return dirs
+}
+
+// resolveMountPath returns p if a volume is mounted at p, or otherwise the
+// mount path of the volume named volume
+func resolveMountPath(mounts []corev1.VolumeMount, p, volume string) string {
+	for _, vm := range mounts {
+		if vm.MountPath == p {
+			return p
+		}
+	}
+	for _, vm := range mounts {
+		if vm.Name == volume {
+			return vm.MountPath
+		}
+	}
+	return p
 }
 
 // getVolumesAndMounts for given directories in the pod
@@ -165,7 +2212,7 @@ func getVolumesAndMounts(dirs []string, pod *corev1.Pod, name string) ([]corev1.
 		}
 	}
 	if c == nil {
-		return nil, nil, fmt.Errorf("container %s not found in pod", name)
+		return nil, nil, fmt.Errorf("%w: container %s not found in pod", ErrContainerMissing, name)
 	}
 
 	mounts := map[string]corev1.VolumeMount{}
@@ -184,13 +2231,13 @@ func getVolumesAndMounts(dirs []string, pod *corev1.Pod, name string) ([]corev1.
 	for _, d := range dirs {
 		m, ok := mounts[d]
 		if !ok {
-			return nil, nil, fmt.Errorf("no volume mount defined for '%s'", d)
+			return nil, nil, fmt.Errorf("%w: no volume mount defined for '%s'", ErrVolumeMissing, d)
 		}
 		vm = append(vm, m)
 
 		v, ok := volumes[m.Name]
 		if !ok {
-			return nil, nil, fmt.Errorf("no volume definition found for volume '%s'", m.Name)
+			return nil, nil, fmt.Errorf("%w: no volume definition found for volume '%s'", ErrVolumeMissing, m.Name)
 		}
 		vtmp[m.Name] = v
 	}
@@ -214,7 +2261,7 @@ func getVolumesAndMounts(dirs []string, pod *corev1.Pod, name string) ([]corev1.
 // when this PMS instance is deleted
 func (p PmsMetadata) OwnerReference() (v1.OwnerReference, error) {
 	if p.UID == "" {
-		return v1.OwnerReference{}, fmt.Errorf("UUID is empty, has Fetch() been run?")
+		return v1.OwnerReference{}, fmt.Errorf("%w: UUID is empty, has Fetch() been run?", ErrIncompleteMetadata)
 	}
 
 	return v1.OwnerReference{
@@ -225,26 +2272,109 @@ func (p PmsMetadata) OwnerReference() (v1.OwnerReference, error) {
 	}, nil
 }
 
+// launcherPort is the port the launcher listens on for the transcoder, which
+// reaches PMS on it through the launcher
+const launcherPort = 32400
+
+// completionFile returns the file the launcher writes its exit code to, the
+// sidecar is signalled through sidecarDoneFile unless a file is set
+func (p PmsMetadata) completionFile() string {
+	if p.CompletionFile == "" && p.Sidecar != nil {
+		return sidecarDoneFile
+	}
+	return p.CompletionFile
+}
+
 // LauncherCmd returns a valid launcher command for this transcode operation
 func (p PmsMetadata) LauncherCmd(args ...string) []string {
+	// On the host network only the transcoder has to reach the launcher, it
+	// isn't exposed on the node
+	listen := fmt.Sprintf(":%d", launcherPort)
+	if p.HostNetwork {
+		listen = fmt.Sprintf("127.0.0.1:%d", launcherPort)
+	}
 	a := []string{
 		"/shared/transcode-launcher",
 		fmt.Sprintf("--pms-addr=%s", p.PmsAddr),
-		"--listen=:32400",
+		fmt.Sprintf("--listen=%s", listen),
 	}
 	if p.CodecPort != 0 {
+		host := p.PodIP
+		switch {
+		case p.CodecBindMode == codecBindNodeIP:
+			host = p.NodeIP
+		case p.CodecBindMode == codecBindLocalhost:
+			host = "127.0.0.1"
+		}
+		u := fmt.Sprintf("http://%s:%d/", host, p.CodecPort)
+		if p.CodecURL != "" {
+			u = p.CodecURL
+		}
 		a = append(a,
-			fmt.Sprintf("--codec-server-url=http://%s:%d/", p.PodIP, p.CodecPort),
+			fmt.Sprintf("--codec-server-url=%s", u),
 			"--codec-dir=/shared/codecs/",
 		)
+		if p.CodecWaitTimeout > 0 {
+			a = append(a, fmt.Sprintf("--codec-server-wait=%s", p.CodecWaitTimeout))
+		}
 	}
 	if p.KubePlexLevel != "" {
 		a = append(a, fmt.Sprintf("--loglevel=%s", p.KubePlexLevel))
+	} else if verboseLevels[ffmpeg.LogLevel(args)] {
+		// Plex verbose transcoder logging enables launcher debug logs
+		a = append(a, "--v=2")
+	}
+	if f := p.completionFile(); f != "" {
+		a = append(a, fmt.Sprintf("--completion-file=%s", f))
+	}
+	if p.PmsRetries > 0 {
+		a = append(a, fmt.Sprintf("--pms-retries=%d", p.PmsRetries))
+	}
+	if p.PmsBackoff > 0 {
+		a = append(a, fmt.Sprintf("--pms-backoff=%s", p.PmsBackoff))
+	}
+	if p.PmsJitter > 0 {
+		a = append(a, fmt.Sprintf("--pms-jitter=%s", strconv.FormatFloat(p.PmsJitter, 'f', -1, 64)))
+	}
+	if p.LauncherMem > 0 {
+		a = append(a, fmt.Sprintf("--max-memory=%d", p.LauncherMem))
+	}
+	if p.LauncherCPU > 0 {
+		a = append(a, fmt.Sprintf("--max-cpu-time=%s", p.LauncherCPU))
 	}
 	a = append(a, "--")
+	if p.TranscoderPath != "" && len(args) > 0 {
+		a = append(a, p.TranscoderPath)
+		args = args[1:]
+	}
 	return append(a, args...)
 }
 
+// shellQuote renders a command as a POSIX shell command line for logging, with
+// arguments containing anything but safe characters single quoted. The result
+// is only for display, commands are run from the argv slice.
+func shellQuote(argv []string) string {
+	q := make([]string, len(argv))
+	for i, a := range argv {
+		q[i] = quoteArg(a)
+	}
+	return strings.Join(q, " ")
+}
+
+// quoteArg quotes a single argument for shellQuote
+func quoteArg(a string) string {
+	if a == "" {
+		return "''"
+	}
+	for _, r := range a {
+		safe := r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("@%+=:,./_-", r)
+		if !safe {
+			return "'" + strings.ReplaceAll(a, "'", `'\''`) + "'"
+		}
+	}
+	return a
+}
+
 // podTemplate is a dummy pod definition that is used to construct a parseable
 // resource for Kubernetes client parser.
 const specTemplate = `
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	batch "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+// runTranscodeOOMRetry runs the transcode with runTranscode. When the
+// transcoder is OOMKilled and kube-plex/oom-auto-bump is set, the transcode is
+// retried once with the memory of the transcode container scaled up. The
+// OOMAutoBump feature is checked before, see featureSettings.
+func runTranscodeOOMRetry(ctx context.Context, cl kubernetes.Interface, m PmsMetadata, job *batch.Job, opts runOptions) error {
+	err := runTranscode(ctx, cl, m, job, opts)
+	if m.OOMBump == 0 || !errors.Is(err, ErrOOMKilled) {
+		return err
+	}
+	bumped, ok := bumpMemory(job, m.TranscodeContainerName(), m.OOMBump, m.OOMBumpMax)
+	if !ok {
+		klog.Infof("%v, memory of the transcode container can't be increased", err)
+		return err
+	}
+	klog.Infof("%v, retrying with %d%% memory", err, int(m.OOMBump*100))
+	return runTranscode(ctx, cl, m, bumped, opts)
+}
+
+// bumpMemory returns a copy of job with the memory request and limit of the
+// named container scaled by factor, up to max bytes if max is set. False is
+// returned if neither could be increased.
+func bumpMemory(job *batch.Job, name string, factor float64, max int64) (*batch.Job, bool) {
+	job = job.DeepCopy()
+	bumped := false
+	for i := range job.Spec.Template.Spec.Containers {
+		c := &job.Spec.Template.Spec.Containers[i]
+		if c.Name != name {
+			continue
+		}
+		for _, rl := range []corev1.ResourceList{c.Resources.Requests, c.Resources.Limits} {
+			q, ok := rl[corev1.ResourceMemory]
+			if !ok {
+				continue
+			}
+			v := int64(float64(q.Value()) * factor)
+			if max > 0 && v > max {
+				v = max
+			}
+			if v > q.Value() {
+				rl[corev1.ResourceMemory] = *resource.NewQuantity(v, resource.BinarySI)
+				bumped = true
+			}
+		}
+	}
+	return job, bumped
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/go-test/deep"
+	batch "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func Test_runTranscodeOOMRetry(t *testing.T) {
+	oomPod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "job-abcde", Namespace: "plex", Labels: map[string]string{"job-name": "job"}},
+		Status: corev1.PodStatus{Phase: corev1.PodFailed, ContainerStatuses: []corev1.ContainerStatus{
+			{Name: "plex", State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 137, Reason: "OOMKilled"}}},
+		}}}
+	job := &batch.Job{ObjectMeta: metav1.ObjectMeta{Name: "job", Namespace: "plex"},
+		Spec: batch.JobSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "plex",
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("1Gi")},
+				Limits:   corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("2Gi")},
+			}}}}}},
+		Status: batch.JobStatus{Failed: 1}}
+
+	// newClient returns a client creating failed jobs with an OOMKilled pod,
+	// except for the retry, and the memory limits of the created jobs
+	newClient := func() (*fake.Clientset, *[]resource.Quantity) {
+		cl := fake.NewSimpleClientset(oomPod)
+		var limits []resource.Quantity
+		cl.PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			j := action.(k8stesting.CreateAction).GetObject().(*batch.Job)
+			limits = append(limits, j.Spec.Template.Spec.Containers[0].Resources.Limits[corev1.ResourceMemory])
+			if len(limits) > 1 {
+				j.Status = batch.JobStatus{Succeeded: 1}
+			}
+			return false, nil, nil
+		})
+		return cl, &limits
+	}
+
+	t.Run("retries with more memory", func(t *testing.T) {
+		cl, limits := newClient()
+		m := PmsMetadata{OOMBump: 1.5, OOMBumpMax: 2560 << 20}
+
+		if err := runTranscodeOOMRetry(context.Background(), cl, m, job, runOptions{}); err != nil {
+			t.Fatalf("runTranscodeOOMRetry() error = %v, want nil", err)
+		}
+		if len(*limits) != 2 {
+			t.Fatalf("runTranscodeOOMRetry() created %d jobs, want 2", len(*limits))
+		}
+		if l := (*limits)[1]; l.Cmp(resource.MustParse("2560Mi")) != 0 {
+			t.Errorf("memory limit of retry = %s, want 2560Mi", l.String())
+		}
+	})
+
+	t.Run("no retry without multiplier", func(t *testing.T) {
+		cl, limits := newClient()
+
+		if err := runTranscodeOOMRetry(context.Background(), cl, PmsMetadata{}, job, runOptions{}); !errors.Is(err, ErrOOMKilled) {
+			t.Errorf("runTranscodeOOMRetry() error = %v, want %v", err, ErrOOMKilled)
+		}
+		if len(*limits) != 1 {
+			t.Errorf("runTranscodeOOMRetry() created %d jobs, want 1", len(*limits))
+		}
+	})
+}
+
+func Test_bumpMemory(t *testing.T) {
+	job := func(req, limit string) *batch.Job {
+		res := corev1.ResourceRequirements{Requests: corev1.ResourceList{}, Limits: corev1.ResourceList{}}
+		if req != "" {
+			res.Requests[corev1.ResourceMemory] = resource.MustParse(req)
+		}
+		if limit != "" {
+			res.Limits[corev1.ResourceMemory] = resource.MustParse(limit)
+		}
+		return &batch.Job{Spec: batch.JobSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{
+			{Name: "sidecar", Resources: corev1.ResourceRequirements{Limits: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("64Mi")}}},
+			{Name: "plex", Resources: res},
+		}}}}}
+	}
+	tests := []struct {
+		name      string
+		job       *batch.Job
+		factor    float64
+		max       string
+		wantReq   string
+		wantLimit string
+		wantOK    bool
+	}{
+		{"scales request and limit", job("1Gi", "2Gi"), 2, "", "2Gi", "4Gi", true},
+		{"caps at maximum", job("1Gi", "2Gi"), 2, "3Gi", "2Gi", "3Gi", true},
+		{"limit at maximum", job("", "2Gi"), 2, "2Gi", "", "2Gi", false},
+		{"no memory set", job("", ""), 2, "", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var max int64
+			if tt.max != "" {
+				q := resource.MustParse(tt.max)
+				max = q.Value()
+			}
+			orig := tt.job.DeepCopy()
+			got, ok := bumpMemory(tt.job, "plex", tt.factor, max)
+			if ok != tt.wantOK {
+				t.Errorf("bumpMemory() ok = %v, want %v", ok, tt.wantOK)
+			}
+			c := got.Spec.Template.Spec.Containers
+			for _, r := range []struct {
+				rl   corev1.ResourceList
+				want string
+			}{{c[1].Resources.Requests, tt.wantReq}, {c[1].Resources.Limits, tt.wantLimit}} {
+				q, set := r.rl[corev1.ResourceMemory]
+				if set != (r.want != "") || set && q.Cmp(resource.MustParse(r.want)) != 0 {
+					t.Errorf("bumpMemory() memory = %s, want %q", q.String(), r.want)
+				}
+			}
+			if l := c[0].Resources.Limits[corev1.ResourceMemory]; l.Cmp(resource.MustParse("64Mi")) != 0 {
+				t.Errorf("bumpMemory() changed memory of other container to %s", l.String())
+			}
+			if diff := deep.Equal(tt.job, orig); diff != nil {
+				t.Errorf("bumpMemory() modified the given job: %v", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	batch "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+)
+
+// imagePullInterval is the interval of checking the transcode pod for image
+// pull errors until it starts
+const imagePullInterval = 5 * time.Second
+
+// Handling of image pull errors of the transcode pod
+const (
+	pullErrorFail  = "fail"  // fail the transcode
+	pullErrorWait  = "wait"  // keep waiting for the kubelet to retry the pull
+	pullErrorLocal = "local" // transcode in the PMS pod instead
+)
+
+// parsePullErrors validates the handling of image pull errors
+func parsePullErrors(v string) (string, error) {
+	switch v {
+	case pullErrorFail, pullErrorWait, pullErrorLocal:
+		return v, nil
+	}
+	return "", fmt.Errorf("invalid handling `%s`, expecting %s, %s or %s", v, pullErrorFail, pullErrorWait, pullErrorLocal)
+}
+
+// volumeCheckInterval is the interval of checking the transcode pod for failing
+// volume attachments and mounts until it starts
+const volumeCheckInterval = 5 * time.Second
+
+// volumeFailureReasons are the reasons of events the kubelet and the attach
+// controller record while a volume of a pod can't be attached or mounted
+var volumeFailureReasons = map[string]bool{"FailedAttachVolume": true, "FailedMount": true}
+
+// failureEvents is the number of warning events of the pod included in the
+// error of a failed transcode
+const failureEvents = 5
+
+// jobPods returns the pods created by the job controller for the job
+func jobPods(ctx context.Context, cl kubernetes.Interface, job *batch.Job) ([]corev1.Pod, error) {
+	sel := labels.Set{"job-name": job.Name}.AsSelector().String()
+	l, err := cl.CoreV1().Pods(job.Namespace).List(ctx, metav1.ListOptions{LabelSelector: sel})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list pods of job %s: %w", job.Name, err)
+	}
+	return l.Items, nil
+}
+
+// containerExitCode returns the exit code of a terminated container in pod
+func containerExitCode(pod corev1.Pod, name string) (int32, bool) {
+	for _, s := range pod.Status.ContainerStatuses {
+		if s.Name == name && s.State.Terminated != nil {
+			return s.State.Terminated.ExitCode, true
+		}
+	}
+	return 0, false
+}
+
+// terminationMessage returns the termination message of a terminated container
+// in pods, empty if there's none
+func terminationMessage(pods []corev1.Pod, name string) string {
+	for _, p := range pods {
+		for _, s := range p.Status.ContainerStatuses {
+			if s.Name == name && s.State.Terminated != nil && s.State.Terminated.Message != "" {
+				return strings.TrimSpace(s.State.Terminated.Message)
+			}
+		}
+	}
+	return ""
+}
+
+// oomKilled returns true if the named container of pods was terminated for
+// running out of memory
+func oomKilled(pods []corev1.Pod, name string) bool {
+	for _, p := range pods {
+		for _, s := range p.Status.ContainerStatuses {
+			if s.Name == name && s.State.Terminated != nil && s.State.Terminated.Reason == "OOMKilled" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// imagePullError returns ErrImagePull with the kubelet message if a container
+// of pods is waiting on an image that can't be pulled
+func imagePullError(pods []corev1.Pod) error {
+	for _, p := range pods {
+		for _, s := range append(p.Status.InitContainerStatuses, p.Status.ContainerStatuses...) {
+			if w := s.State.Waiting; w != nil && (w.Reason == "ErrImagePull" || w.Reason == "ImagePullBackOff") {
+				return fmt.Errorf("%w: container %s of pod %s: %s: %s", ErrImagePull, s.Name, p.Name, w.Reason, w.Message)
+			}
+		}
+	}
+	return nil
+}
+
+// podsStarted returns true if any of pods is past the pending phase, its
+// images have been pulled then
+func podsStarted(pods []corev1.Pod) bool {
+	for _, p := range pods {
+		if p.Status.Phase != corev1.PodPending && p.Status.Phase != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// podsScheduled returns true if any of pods has been scheduled to a node
+func podsScheduled(pods []corev1.Pod) bool {
+	for _, p := range pods {
+		if p.Spec.NodeName != "" || p.Status.Phase != corev1.PodPending && p.Status.Phase != "" {
+			return true
+		}
+		for _, c := range p.Status.Conditions {
+			if c.Type == corev1.PodScheduled && c.Status == corev1.ConditionTrue {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// podEvents returns the events recorded for pods
+func podEvents(ctx context.Context, cl kubernetes.Interface, pods []corev1.Pod) ([]corev1.Event, error) {
+	var events []corev1.Event
+	for _, p := range pods {
+		sel := fields.Set{"involvedObject.kind": "Pod", "involvedObject.name": p.Name}.AsSelector().String()
+		l, err := cl.CoreV1().Events(p.Namespace).List(ctx, metav1.ListOptions{FieldSelector: sel})
+		if err != nil {
+			return nil, fmt.Errorf("unable to list events of pod %s: %w", p.Name, err)
+		}
+		for _, e := range l.Items {
+			if e.InvolvedObject.Name == p.Name {
+				events = append(events, e)
+			}
+		}
+	}
+	return events, nil
+}
+
+// volumeFailure returns the message of the most recent volume attach or mount
+// failure in events, empty if there's none
+func volumeFailure(events []corev1.Event) string {
+	var last *corev1.Event
+	for i, e := range events {
+		if e.Type == corev1.EventTypeWarning && volumeFailureReasons[e.Reason] && (last == nil || !eventTime(e).Before(eventTime(*last))) {
+			last = &events[i]
+		}
+	}
+	if last == nil {
+		return ""
+	}
+	return fmt.Sprintf("%s: %s", last.Reason, last.Message)
+}
+
+// recentWarnings returns the messages of the last n warning events, oldest
+// first
+func recentWarnings(events []corev1.Event, n int) []string {
+	var warnings []corev1.Event
+	for _, e := range events {
+		if e.Type == corev1.EventTypeWarning {
+			warnings = append(warnings, e)
+		}
+	}
+	sort.SliceStable(warnings, func(i, j int) bool { return eventTime(warnings[i]).Before(eventTime(warnings[j])) })
+	if len(warnings) > n {
+		warnings = warnings[len(warnings)-n:]
+	}
+	var out []string
+	for _, e := range warnings {
+		out = append(out, fmt.Sprintf("%s: %s", e.Reason, e.Message))
+	}
+	return out
+}
+
+// eventTime returns the time an event was last seen
+func eventTime(e corev1.Event) time.Time {
+	switch {
+	case !e.LastTimestamp.IsZero():
+		return e.LastTimestamp.Time
+	case !e.EventTime.IsZero():
+		return e.EventTime.Time
+	}
+	return e.FirstTimestamp.Time
+}
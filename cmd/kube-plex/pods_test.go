@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func Test_parsePullErrors(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"fail", "fail", false},
+		{"wait", "wait", false},
+		{"local", "local", false},
+		{"", "", true},
+		{"retry", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parsePullErrors(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parsePullErrors() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parsePullErrors() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
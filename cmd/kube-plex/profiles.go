@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/munnerz/kube-plex/internal/ffmpeg"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/klog/v2"
+)
+
+// Well known resource profile names, see selectResourceProfile
+const (
+	profileAudio   = "audio"
+	profile4K      = "4k"
+	profileCodec   = "codec:"
+	profileDefault = "default"
+)
+
+// NVIDIA GPU resource names, MIG partitions are advertised as e.g.
+// nvidia.com/mig-1g.5gb
+const (
+	resourceGPU       = "nvidia.com/gpu"
+	resourceMIGPrefix = "nvidia.com/mig-"
+)
+
+// gpuProductLabel is the node label GPU feature discovery sets to the GPU
+// model, e.g. NVIDIA-A10
+const gpuProductLabel = "nvidia.com/gpu.product"
+
+// checkGPUResources verifies that whole GPUs and MIG partitions aren't both
+// requested, a container gets either one or the other
+func checkGPUResources(r corev1.ResourceRequirements) error {
+	var gpu, mig bool
+	for _, rl := range []corev1.ResourceList{r.Requests, r.Limits} {
+		for n := range rl {
+			gpu = gpu || n == resourceGPU
+			mig = mig || strings.HasPrefix(string(n), resourceMIGPrefix)
+		}
+	}
+	if gpu && mig {
+		return fmt.Errorf("both %s and MIG resources requested", resourceGPU)
+	}
+	return nil
+}
+
+// hasGPUResources reports whether whole GPUs or MIG partitions are requested
+func hasGPUResources(r corev1.ResourceRequirements) bool {
+	for _, rl := range []corev1.ResourceList{r.Requests, r.Limits} {
+		for n := range rl {
+			if n == resourceGPU || strings.HasPrefix(string(n), resourceMIGPrefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// requestsGPU reports whether the transcode container requests GPUs, either
+// in the effective resources or in any of the resource profiles
+func (p PmsMetadata) requestsGPU() bool {
+	if hasGPUResources(p.withExtraResources(p.ResourceRequirements())) {
+		return true
+	}
+	for _, r := range p.ResourceProfiles {
+		if hasGPUResources(p.withExtraResources(r)) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseResourceProfiles parses a JSON map of profile names to container
+// resource requirements, e.g.
+//
+//	{"audio": {"requests": {"cpu": "250m"}}, "4k": {"limits": {"cpu": "8"}}}
+func parseResourceProfiles(j string) (map[string]corev1.ResourceRequirements, error) {
+	var p map[string]corev1.ResourceRequirements
+	d := json.NewDecoder(strings.NewReader(j))
+	d.DisallowUnknownFields()
+	if err := d.Decode(&p); err != nil {
+		return nil, fmt.Errorf("unable to parse resource profiles: %v", err)
+	}
+	for n := range p {
+		if n == "" || n == profileCodec {
+			return nil, fmt.Errorf("invalid profile name `%s`", n)
+		}
+	}
+	return p, nil
+}
+
+// selectResourceProfile picks a resource profile for a transcode based on the
+// transcoder arguments. The first defined profile is selected from:
+//
+//	audio        - all input streams are audio, e.g. music transcodes
+//	4k           - output is scaled to 2160 lines or more
+//	codec:<name> - decoder of the input video stream, e.g. codec:hevc
+//	default
+//
+// No profile is selected if none of these are defined.
+func selectResourceProfile(profiles map[string]corev1.ResourceRequirements, i ffmpeg.Info) (string, bool) {
+	var names []string
+	if i.AudioOnly() {
+		names = append(names, profileAudio)
+	}
+	if i.Height >= 2160 {
+		names = append(names, profile4K)
+	}
+	if c := i.VideoCodec(); c != "" {
+		names = append(names, profileCodec+c)
+	}
+	names = append(names, profileDefault)
+
+	for _, n := range names {
+		if _, ok := profiles[n]; ok {
+			return n, true
+		}
+	}
+	return "", false
+}
+
+// codecResources adjusts the transcode resources for an output video codec,
+// either with a named resource profile or by scaling cpu and memory
+type codecResources struct {
+	Profile    string  `json:"profile,omitempty"`
+	Multiplier float64 `json:"multiplier,omitempty"`
+}
+
+// parseCodecResources parses a JSON map of output video codecs to resource
+// adjustments, e.g.
+//
+//	{"hevc": {"multiplier": 2}, "av1": {"profile": "4k"}}
+//
+// Profiles must be defined in profiles.
+func parseCodecResources(j string, profiles map[string]corev1.ResourceRequirements) (map[string]codecResources, error) {
+	var c map[string]codecResources
+	d := json.NewDecoder(strings.NewReader(j))
+	d.DisallowUnknownFields()
+	if err := d.Decode(&c); err != nil {
+		return nil, fmt.Errorf("unable to parse codec resources: %v", err)
+	}
+	for n, cr := range c {
+		if n == "" {
+			return nil, fmt.Errorf("empty codec name")
+		}
+		if cr.Multiplier < 0 {
+			return nil, fmt.Errorf("codec %s: negative multiplier %v", n, cr.Multiplier)
+		}
+		if cr.Profile == "" && cr.Multiplier == 0 {
+			return nil, fmt.Errorf("codec %s: a profile or a multiplier is required", n)
+		}
+		if _, ok := profiles[cr.Profile]; cr.Profile != "" && !ok {
+			return nil, fmt.Errorf("codec %s: unknown resource profile `%s`", n, cr.Profile)
+		}
+	}
+	return c, nil
+}
+
+// scaleResources returns a copy of rl with cpu and memory multiplied by f.
+// Other resources, such as devices, are left as they are.
+func scaleResources(rl corev1.ResourceList, f float64) corev1.ResourceList {
+	if rl == nil {
+		return nil
+	}
+	out := make(corev1.ResourceList, len(rl))
+	for n, q := range rl {
+		switch n {
+		case corev1.ResourceCPU:
+			q = *resource.NewMilliQuantity(int64(math.Ceil(float64(q.MilliValue())*f)), q.Format)
+		case corev1.ResourceMemory:
+			q = *resource.NewQuantity(int64(math.Ceil(float64(q.Value())*f)), q.Format)
+		}
+		out[n] = q
+	}
+	return out
+}
+
+// TranscodeResources returns the resource requirements for the transcode
+// container. Selected resource profile replaces the requests and limits set
+// with annotations, and is then adjusted for the output video codec: a codec
+// profile replaces the resources again and a multiplier scales them. Extra
+// resources are added to both requests and limits, as extended resources can't
+// be overcommitted.
+func (p PmsMetadata) TranscodeResources(args []string) corev1.ResourceRequirements {
+	r := p.ResourceRequirements()
+	if n, ok := selectResourceProfile(p.ResourceProfiles, ffmpeg.ParseArgs(args)); ok {
+		klog.V(1).Infof("Using resource profile %s for transcode", n)
+		r = p.ResourceProfiles[n]
+	}
+	if c := ffmpeg.VideoEncoderCodec(args); c != "" {
+		if cr, ok := p.CodecResources[c]; ok {
+			if cr.Profile != "" {
+				klog.V(1).Infof("Using resource profile %s for %s output", cr.Profile, c)
+				r = p.ResourceProfiles[cr.Profile]
+			}
+			if cr.Multiplier > 0 {
+				r = corev1.ResourceRequirements{Requests: scaleResources(r.Requests, cr.Multiplier), Limits: scaleResources(r.Limits, cr.Multiplier)}
+			}
+		}
+	}
+	return p.withExtraResources(r)
+}
+
+// withExtraResources returns r with extra resources added
+func (p PmsMetadata) withExtraResources(r corev1.ResourceRequirements) corev1.ResourceRequirements {
+	if len(p.ExtraResources) == 0 {
+		return r
+	}
+	return corev1.ResourceRequirements{
+		Requests: addResources(r.Requests, p.ExtraResources),
+		Limits:   addResources(r.Limits, p.ExtraResources),
+	}
+}
+
+// addResources returns a copy of rl with the resources in extra added
+func addResources(rl, extra corev1.ResourceList) corev1.ResourceList {
+	out := make(corev1.ResourceList, len(rl)+len(extra))
+	for n, q := range rl {
+		out[n] = q
+	}
+	for n, q := range extra {
+		out[n] = q
+	}
+	return out
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/munnerz/kube-plex/internal/ffmpeg"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func Test_parseResourceProfiles(t *testing.T) {
+	cpu := resource.MustParse("250m")
+	tests := []struct {
+		name    string
+		in      string
+		want    map[string]corev1.ResourceRequirements
+		wantErr bool
+	}{
+		{"parses profiles", `{"audio": {"requests": {"cpu": "250m"}}, "codec:hevc": {"limits": {"cpu": "250m"}}}`,
+			map[string]corev1.ResourceRequirements{
+				"audio":      {Requests: corev1.ResourceList{corev1.ResourceCPU: cpu}},
+				"codec:hevc": {Limits: corev1.ResourceList{corev1.ResourceCPU: cpu}},
+			}, false},
+		{"invalid json", `{"audio": `, nil, true},
+		{"unknown fields", `{"audio": {"cpu": "250m"}}`, nil, true},
+		{"invalid quantity", `{"audio": {"requests": {"cpu": "lots"}}}`, nil, true},
+		{"empty codec profile", `{"codec:": {}}`, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseResourceProfiles(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseResourceProfiles() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if diff := deep.Equal(got, tt.want); diff != nil {
+				t.Errorf("parseResourceProfiles() diff: %v", diff)
+			}
+		})
+	}
+}
+
+func Test_selectResourceProfile(t *testing.T) {
+	all := map[string]corev1.ResourceRequirements{"audio": {}, "4k": {}, "codec:hevc": {}, "default": {}}
+	tests := []struct {
+		name     string
+		profiles map[string]corev1.ResourceRequirements
+		info     ffmpeg.Info
+		want     string
+		wantOk   bool
+	}{
+		{"no profiles", nil, ffmpeg.Info{Codecs: []string{"hevc"}}, "", false},
+		{"audio", all, ffmpeg.Info{Codecs: []string{"flac", "mjpeg"}}, "audio", true},
+		{"4k", all, ffmpeg.Info{Codecs: []string{"hevc", "eac3"}, Height: 2160}, "4k", true},
+		{"codec", all, ffmpeg.Info{Codecs: []string{"hevc", "eac3"}, Height: 1080}, "codec:hevc", true},
+		{"default", all, ffmpeg.Info{Codecs: []string{"h264"}}, "default", true},
+		{"falls through undefined profiles", map[string]corev1.ResourceRequirements{"default": {}}, ffmpeg.Info{Codecs: []string{"hevc"}, Height: 2160}, "default", true},
+		{"no matching profile", map[string]corev1.ResourceRequirements{"audio": {}}, ffmpeg.Info{Codecs: []string{"h264"}}, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := selectResourceProfile(tt.profiles, tt.info)
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("selectResourceProfile() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestPmsMetadata_TranscodeResources(t *testing.T) {
+	cpu := resource.MustParse("1")
+	base := corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")}
+	m := PmsMetadata{
+		ResourceRequests: base,
+		ResourceProfiles: map[string]corev1.ResourceRequirements{"codec:hevc": {Requests: corev1.ResourceList{corev1.ResourceCPU: cpu}}},
+	}
+
+	got := m.TranscodeResources([]string{"-codec:0", "hevc", "-i", "/data/movie.mkv"})
+	if diff := deep.Equal(got, m.ResourceProfiles["codec:hevc"]); diff != nil {
+		t.Errorf("TranscodeResources() profile diff: %v", diff)
+	}
+
+	got = m.TranscodeResources([]string{"-codec:0", "h264", "-i", "/data/movie.mkv"})
+	if diff := deep.Equal(got, corev1.ResourceRequirements{Requests: base}); diff != nil {
+		t.Errorf("TranscodeResources() fallback diff: %v", diff)
+	}
+}
+
+func Test_parseCodecResources(t *testing.T) {
+	profiles := map[string]corev1.ResourceRequirements{"heavy": {}}
+	tests := []struct {
+		name    string
+		in      string
+		want    map[string]codecResources
+		wantErr bool
+	}{
+		{"multiplier and profile", `{"hevc": {"multiplier": 2}, "av1": {"profile": "heavy", "multiplier": 1.5}}`,
+			map[string]codecResources{"hevc": {Multiplier: 2}, "av1": {Profile: "heavy", Multiplier: 1.5}}, false},
+		{"unknown profile", `{"hevc": {"profile": "light"}}`, nil, true},
+		{"negative multiplier", `{"hevc": {"multiplier": -1}}`, nil, true},
+		{"no adjustment", `{"hevc": {}}`, nil, true},
+		{"empty codec", `{"": {"multiplier": 2}}`, nil, true},
+		{"unknown field", `{"hevc": {"factor": 2}}`, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseCodecResources(tt.in, profiles)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseCodecResources() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if diff := deep.Equal(got, tt.want); diff != nil {
+				t.Errorf("parseCodecResources() diff: %v", diff)
+			}
+		})
+	}
+}
+
+func TestPmsMetadata_TranscodeResources_codec(t *testing.T) {
+	gpu := resource.MustParse("1")
+	m := PmsMetadata{
+		ResourceRequests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("500m"), corev1.ResourceMemory: resource.MustParse("1Gi"), resourceGPU: gpu},
+		ResourceLimits:   corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("2")},
+		ResourceProfiles: map[string]corev1.ResourceRequirements{
+			"4k":    {Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("4")}},
+			"heavy": {Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("3")}},
+		},
+		CodecResources: map[string]codecResources{"hevc": {Multiplier: 1.5}, "av1": {Profile: "heavy"}, "vp9": {Profile: "heavy", Multiplier: 2}},
+	}
+
+	tests := []struct {
+		name string
+		args string
+		want corev1.ResourceRequirements
+	}{
+		{"no adjustment", "-codec:0 h264 -i /data/movie.mkv -codec:0 libx264", m.ResourceRequirements()},
+		{"multiplier", "-codec:0 h264 -i /data/movie.mkv -codec:1 aac -codec:0 libx265", corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("750m"), corev1.ResourceMemory: resource.MustParse("1536Mi"), resourceGPU: gpu},
+			Limits:   corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("3")},
+		}},
+		{"multiplier on selected profile", "-codec:0 h264 -i /data/movie.mkv -vf scale=3840:2160 -c:v hevc_nvenc", corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("6")},
+		}},
+		{"codec profile", "-codec:0 h264 -i /data/movie.mkv -vf scale=3840:2160 -codec:0 libaom-av1", m.ResourceProfiles["heavy"]},
+		{"codec profile and multiplier", "-i /data/movie.mkv -c:v libvpx-vp9", corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("6")},
+		}},
+		{"copied video", "-codec:0 hevc -i /data/movie.mkv -codec:0 copy", m.ResourceRequirements()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := m.TranscodeResources(strings.Fields(tt.args))
+			for _, rl := range []struct{ got, want corev1.ResourceList }{{got.Requests, tt.want.Requests}, {got.Limits, tt.want.Limits}} {
+				if len(rl.got) != len(rl.want) {
+					t.Errorf("TranscodeResources() = %v, want %v", got, tt.want)
+				}
+				for n, q := range rl.want {
+					if g := rl.got[n]; g.Cmp(q) != 0 {
+						t.Errorf("TranscodeResources() %s = %s, want %s", n, g.String(), q.String())
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestPmsMetadata_TranscodeResources_extra(t *testing.T) {
+	cpu := resource.MustParse("1")
+	efa := resource.MustParse("1")
+	m := PmsMetadata{
+		ResourceRequests: corev1.ResourceList{corev1.ResourceCPU: cpu},
+		ExtraResources:   corev1.ResourceList{"vpc.amazonaws.com/efa": efa},
+	}
+
+	want := corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{corev1.ResourceCPU: cpu, "vpc.amazonaws.com/efa": efa},
+		Limits:   corev1.ResourceList{"vpc.amazonaws.com/efa": efa},
+	}
+	if diff := deep.Equal(m.TranscodeResources(nil), want); diff != nil {
+		t.Errorf("TranscodeResources() diff: %v", diff)
+	}
+	if _, ok := m.ResourceRequests["vpc.amazonaws.com/efa"]; ok {
+		t.Errorf("TranscodeResources() modified resource requests of metadata")
+	}
+}
+
+func Test_checkGPUResources(t *testing.T) {
+	one := resource.MustParse("1")
+	tests := []struct {
+		name    string
+		r       corev1.ResourceRequirements
+		wantErr bool
+	}{
+		{"no GPUs", corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceCPU: one}}, false},
+		{"whole GPU", corev1.ResourceRequirements{Limits: corev1.ResourceList{"nvidia.com/gpu": one}}, false},
+		{"MIG partitions", corev1.ResourceRequirements{Limits: corev1.ResourceList{"nvidia.com/mig-1g.5gb": one, "nvidia.com/mig-2g.10gb": one}}, false},
+		{"MIG and whole GPU", corev1.ResourceRequirements{Limits: corev1.ResourceList{"nvidia.com/mig-1g.5gb": one, "nvidia.com/gpu": one}}, true},
+		{"MIG and whole GPU in requests and limits", corev1.ResourceRequirements{Requests: corev1.ResourceList{"nvidia.com/mig-1g.5gb": one}, Limits: corev1.ResourceList{"nvidia.com/gpu": one}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkGPUResources(tt.r); (err != nil) != tt.wantErr {
+				t.Errorf("checkGPUResources() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	batch "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+// quotaResources are the resources counted both by their plain name and with
+// the `requests.` prefix in resource quotas
+var quotaResources = map[corev1.ResourceName]bool{
+	corev1.ResourceCPU:              true,
+	corev1.ResourceMemory:           true,
+	corev1.ResourceEphemeralStorage: true,
+}
+
+// checkQuota is a best effort check that the transcode pod and job fit in the
+// resource quotas of the job namespace. Quotas are only read, other pods can
+// still use up the remaining quota before the transcode pod is created. Quotas
+// with scopes are skipped.
+func checkQuota(ctx context.Context, cl kubernetes.Interface, job *batch.Job) error {
+	l, err := cl.CoreV1().ResourceQuotas(job.Namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to list resource quotas: %w", err)
+	}
+
+	usage := podQuotaUsage(job.Spec.Template.Spec)
+	usage["count/jobs.batch"] = resource.MustParse("1")
+	for _, q := range l.Items {
+		if len(q.Spec.Scopes) > 0 || q.Spec.ScopeSelector != nil {
+			klog.V(1).Infof("Skipping quota check for scoped quota %s", q.Name)
+			continue
+		}
+		for n, want := range usage {
+			hard, ok := q.Spec.Hard[n]
+			if !ok {
+				continue
+			}
+			used := q.Status.Used[n].DeepCopy()
+			used.Add(want)
+			if used.Cmp(hard) > 0 {
+				u := q.Status.Used[n]
+				return fmt.Errorf("%w: transcode needs %s of %s in quota %s, %s of %s used", ErrQuotaExceeded, want.String(), n, q.Name, u.String(), hard.String())
+			}
+		}
+	}
+	return nil
+}
+
+// managedByLabel marks transcode pods counted for the concurrency limit
+const (
+	managedByLabel = "app.kubernetes.io/managed-by"
+	managedByValue = "kube-plex"
+)
+
+// checkConcurrentTranscodes returns ErrTooManyTranscodes when max or more
+// active transcode pods labeled with managedByLabel exist in the namespace.
+// Like checkQuota this is a best effort check: transcodes started at the same
+// time all see the same count and can exceed the limit together.
+func checkConcurrentTranscodes(ctx context.Context, cl kubernetes.Interface, namespace string, max int) error {
+	sel := labels.Set{managedByLabel: managedByValue}.AsSelector().String()
+	l, err := cl.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: sel})
+	if err != nil {
+		return fmt.Errorf("unable to list transcode pods: %w", err)
+	}
+	active := 0
+	for _, p := range l.Items {
+		if p.DeletionTimestamp == nil && (p.Status.Phase == corev1.PodPending || p.Status.Phase == corev1.PodRunning || p.Status.Phase == "") {
+			active++
+		}
+	}
+	if active >= max {
+		return fmt.Errorf("%w: %d transcode pods active in namespace %s, the maximum is %d", ErrTooManyTranscodes, active, namespace, max)
+	}
+	return nil
+}
+
+// podQuotaUsage returns the quota usage of a pod, with the resource names used
+// in resource quotas. The effective request of a resource is the larger of the
+// sum over containers and the largest init container, plus pod overhead.
+// Containers without a request use their limit, as with API defaulting.
+func podQuotaUsage(spec corev1.PodSpec) corev1.ResourceList {
+	requests, limits := corev1.ResourceList{}, corev1.ResourceList{}
+	for _, c := range spec.Containers {
+		addQuantities(requests, containerRequests(c))
+		addQuantities(limits, c.Resources.Limits)
+	}
+	for _, c := range spec.InitContainers {
+		maxQuantities(requests, containerRequests(c))
+		maxQuantities(limits, c.Resources.Limits)
+	}
+	addQuantities(requests, spec.Overhead)
+	addQuantities(limits, spec.Overhead)
+
+	usage := corev1.ResourceList{
+		corev1.ResourcePods:               resource.MustParse("1"),
+		corev1.ResourceName("count/pods"): resource.MustParse("1"),
+	}
+	for n, q := range requests {
+		if quotaResources[n] {
+			usage[n] = q
+		}
+		usage[corev1.ResourceName("requests."+string(n))] = q
+	}
+	for n, q := range limits {
+		if quotaResources[n] {
+			usage[corev1.ResourceName("limits."+string(n))] = q
+		}
+	}
+	return usage
+}
+
+// containerRequests returns the requests of a container, defaulted from limits
+func containerRequests(c corev1.Container) corev1.ResourceList {
+	r := corev1.ResourceList{}
+	for n, q := range c.Resources.Limits {
+		r[n] = q
+	}
+	for n, q := range c.Resources.Requests {
+		r[n] = q
+	}
+	return r
+}
+
+// addQuantities adds the quantities in src to dst
+func addQuantities(dst, src corev1.ResourceList) {
+	for n, q := range src {
+		v := dst[n].DeepCopy()
+		v.Add(q)
+		dst[n] = v
+	}
+}
+
+// maxQuantities sets the quantities in dst to the larger of dst and src
+func maxQuantities(dst, src corev1.ResourceList) {
+	for n, q := range src {
+		if v, ok := dst[n]; !ok || q.Cmp(v) > 0 {
+			dst[n] = q
+		}
+	}
+}
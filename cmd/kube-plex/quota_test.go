@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/go-test/deep"
+	batch "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func Test_checkQuota(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	job := &batch.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: "job", Namespace: "plex"},
+		Spec: batch.JobSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "plex", Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("2")},
+			}}},
+		}}},
+	}
+	quota := func(name string, hard, used corev1.ResourceList, scopes ...corev1.ResourceQuotaScope) *corev1.ResourceQuota {
+		return &corev1.ResourceQuota{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "plex"},
+			Spec:       corev1.ResourceQuotaSpec{Hard: hard, Scopes: scopes},
+			Status:     corev1.ResourceQuotaStatus{Hard: hard, Used: used},
+		}
+	}
+	tests := []struct {
+		name    string
+		quotas  []*corev1.ResourceQuota
+		wantErr error
+	}{
+		{"no quotas", nil, nil},
+		{"fits", []*corev1.ResourceQuota{quota("cpu", corev1.ResourceList{"requests.cpu": resource.MustParse("4")}, corev1.ResourceList{"requests.cpu": resource.MustParse("2")})}, nil},
+		{"cpu exceeded", []*corev1.ResourceQuota{quota("cpu", corev1.ResourceList{"cpu": resource.MustParse("4")}, corev1.ResourceList{"cpu": resource.MustParse("2500m")})}, ErrQuotaExceeded},
+		{"pods exceeded", []*corev1.ResourceQuota{quota("pods", corev1.ResourceList{"pods": resource.MustParse("10")}, corev1.ResourceList{"pods": resource.MustParse("10")})}, ErrQuotaExceeded},
+		{"jobs exceeded", []*corev1.ResourceQuota{quota("jobs", corev1.ResourceList{"count/jobs.batch": resource.MustParse("1")}, corev1.ResourceList{"count/jobs.batch": resource.MustParse("1")})}, ErrQuotaExceeded},
+		{"unused resource", []*corev1.ResourceQuota{quota("memory", corev1.ResourceList{"requests.memory": resource.MustParse("1Gi")}, corev1.ResourceList{"requests.memory": resource.MustParse("1Gi")})}, nil},
+		{"scoped quota skipped", []*corev1.ResourceQuota{quota("besteffort", corev1.ResourceList{"pods": resource.MustParse("0")}, nil, corev1.ResourceQuotaScopeBestEffort)}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cl := fake.NewSimpleClientset()
+			for _, q := range tt.quotas {
+				cl.Tracker().Add(q)
+			}
+			if err := checkQuota(ctx, cl, job); !errors.Is(err, tt.wantErr) {
+				t.Errorf("checkQuota() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_podQuotaUsage(t *testing.T) {
+	spec := corev1.PodSpec{
+		Containers: []corev1.Container{
+			{Name: "plex", Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")},
+				Limits:   corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("2"), corev1.ResourceMemory: resource.MustParse("1Gi")},
+			}},
+			{Name: "sidecar", Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("500m"), "nvidia.com/gpu": resource.MustParse("1")},
+			}},
+		},
+		InitContainers: []corev1.Container{{Name: "kube-plex-init", Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("2"), corev1.ResourceMemory: resource.MustParse("64Mi")},
+		}}},
+		Overhead: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("128Mi")},
+	}
+	want := map[string]string{
+		"pods":                    "1",
+		"count/pods":              "1",
+		"cpu":                     "2",
+		"requests.cpu":            "2",
+		"memory":                  "1152Mi",
+		"requests.memory":         "1152Mi",
+		"requests.nvidia.com/gpu": "1",
+		"limits.cpu":              "2",
+		"limits.memory":           "1152Mi",
+	}
+	got := map[string]string{}
+	for n, q := range podQuotaUsage(spec) {
+		got[string(n)] = q.String()
+	}
+	if diff := deep.Equal(got, want); diff != nil {
+		t.Errorf("podQuotaUsage() diff: %v", diff)
+	}
+}
+
+func Test_checkConcurrentTranscodes(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	transcode := func(name string, phase corev1.PodPhase) runtime.Object {
+		return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "plex", Name: name, Labels: map[string]string{"app.kubernetes.io/managed-by": "kube-plex"}}, Status: corev1.PodStatus{Phase: phase}}
+	}
+	now := metav1.Now()
+	pods := []runtime.Object{
+		transcode("running", corev1.PodRunning),
+		transcode("pending", corev1.PodPending),
+		transcode("succeeded", corev1.PodSucceeded),
+		transcode("failed", corev1.PodFailed),
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "plex", Name: "deleting", DeletionTimestamp: &now, Labels: map[string]string{"app.kubernetes.io/managed-by": "kube-plex"}}, Status: corev1.PodStatus{Phase: corev1.PodRunning}},
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "plex", Name: "pms"}, Status: corev1.PodStatus{Phase: corev1.PodRunning}},
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "other", Name: "running", Labels: map[string]string{"app.kubernetes.io/managed-by": "kube-plex"}}, Status: corev1.PodStatus{Phase: corev1.PodRunning}},
+	}
+
+	tests := []struct {
+		name    string
+		max     int
+		wantErr error
+	}{
+		{"below limit", 3, nil},
+		{"at limit", 2, ErrTooManyTranscodes},
+		{"over limit", 1, ErrTooManyTranscodes},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cl := fake.NewSimpleClientset(pods...)
+			if err := checkConcurrentTranscodes(ctx, cl, "plex", tt.max); !errors.Is(err, tt.wantErr) {
+				t.Errorf("checkConcurrentTranscodes() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
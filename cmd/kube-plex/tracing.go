@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer is used for all kube-plex spans. It's a no-op until setupTracing
+// installs a tracer provider.
+var tracer = otel.Tracer("github.com/munnerz/kube-plex")
+
+// setupTracing exports spans over OTLP/HTTP when an OTLP endpoint is configured
+// with the standard OTEL_EXPORTER_OTLP_* environment variables. The returned
+// function flushes and stops the exporter.
+func setupTracing(ctx context.Context) (func(context.Context) error, error) {
+	noop := func(context.Context) error { return nil }
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		return noop, nil
+	}
+
+	exp, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return noop, fmt.Errorf("unable to create OTLP exporter: %w", err)
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String("kube-plex"))),
+	)
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
+
+// traceEnvVars are the environment variables carrying trace context, named
+// after the W3C headers
+var traceEnvVars = []string{"TRACEPARENT", "TRACESTATE", "BAGGAGE"}
+
+// envPropagator reads and writes the trace context headers of traceEnvVars
+var envPropagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// traceParent returns a context carrying the remote span context and baggage
+// given in TRACEPARENT, TRACESTATE and BAGGAGE environment variables, if any
+func traceParent(ctx context.Context) context.Context {
+	h := http.Header{}
+	for _, v := range traceEnvVars {
+		h.Set(v, os.Getenv(v))
+	}
+	return envPropagator.Extract(ctx, propagation.HeaderCarrier(h))
+}
+
+// withTraceEnv returns env with the trace context variables replaced by the
+// trace context of ctx, so that the transcoder can continue the trace
+func withTraceEnv(ctx context.Context, env []string) []string {
+	h := http.Header{}
+	envPropagator.Inject(ctx, propagation.HeaderCarrier(h))
+
+	out := []string{}
+	for _, e := range env {
+		if !isTraceEnv(e) {
+			out = append(out, e)
+		}
+	}
+	for _, v := range traceEnvVars {
+		if val := h.Get(v); val != "" {
+			out = append(out, v+"="+val)
+		}
+	}
+	return out
+}
+
+// isTraceEnv reports whether the environment entry is one of traceEnvVars
+func isTraceEnv(e string) bool {
+	for _, v := range traceEnvVars {
+		if strings.HasPrefix(e, v+"=") {
+			return true
+		}
+	}
+	return false
+}
+
+// endSpan records err on span, if set, and ends the span
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	batch "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func Test_traceParent(t *testing.T) {
+	defer os.Unsetenv("TRACEPARENT")
+
+	if sc := trace.SpanContextFromContext(traceParent(context.Background())); sc.IsValid() {
+		t.Errorf("traceParent() returned a span context without TRACEPARENT: %v", sc)
+	}
+
+	os.Setenv("TRACEPARENT", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	sc := trace.SpanContextFromContext(traceParent(context.Background()))
+	if !sc.IsRemote() || sc.TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("traceParent() = %v, want remote span context of trace 4bf92f3577b34da6a3ce929d0e0e4736", sc)
+	}
+}
+
+func Test_withTraceEnv(t *testing.T) {
+	defer os.Unsetenv("TRACEPARENT")
+	defer os.Unsetenv("BAGGAGE")
+	os.Setenv("TRACEPARENT", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	os.Setenv("BAGGAGE", "session=abc")
+
+	ctx, span := sdktrace.NewTracerProvider().Tracer("test").Start(traceParent(context.Background()), "Transcode")
+	defer span.End()
+	env := withTraceEnv(ctx, []string{"PATH=/bin", "TRACEPARENT=00-00000000000000000000000000000001-0000000000000001-01"})
+
+	want := []string{"PATH=/bin",
+		"TRACEPARENT=00-4bf92f3577b34da6a3ce929d0e0e4736-" + span.SpanContext().SpanID().String() + "-01",
+		"BAGGAGE=session=abc",
+	}
+	if !reflect.DeepEqual(env, want) {
+		t.Errorf("withTraceEnv() = %v, want %v", env, want)
+	}
+
+	if env := withTraceEnv(context.Background(), []string{"PATH=/bin", "TRACESTATE=a=b"}); !reflect.DeepEqual(env, []string{"PATH=/bin"}) {
+		t.Errorf("withTraceEnv() without trace context = %v, want [PATH=/bin]", env)
+	}
+}
+
+func Test_setupTracing_noop(t *testing.T) {
+	shutdown, err := setupTracing(context.Background())
+	if err != nil {
+		t.Fatalf("setupTracing() error = %v", err)
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Errorf("shutdown() error = %v", err)
+	}
+}
+
+func Test_runTranscode_spans(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr)))
+	defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+
+	cl := fake.NewSimpleClientset()
+	job := &batch.Job{ObjectMeta: metav1.ObjectMeta{Name: "job", Namespace: "plex"}, Status: batch.JobStatus{Succeeded: 1}}
+	if err := runTranscode(context.Background(), cl, PmsMetadata{}, job, runOptions{}); err != nil {
+		t.Fatalf("runTranscode() error = %v", err)
+	}
+
+	var names []string
+	for _, s := range sr.Ended() {
+		names = append(names, s.Name())
+	}
+	if len(names) != 2 || names[0] != "CreateJob" || names[1] != "WaitForCompletion" {
+		t.Errorf("recorded spans = %v, want [CreateJob WaitForCompletion]", names)
+	}
+}
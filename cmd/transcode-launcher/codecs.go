@@ -2,15 +2,57 @@ package main
 
 import (
 	"archive/tar"
+	"context"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"time"
 
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/klog/v2"
 )
 
+// codecPollInterval is the interval of codec server health checks
+var codecPollInterval = 500 * time.Millisecond
+
+// waitForCodecServer waits until the health endpoint of the codec server at
+// serverURL responds with a 2xx status, or the timeout expires
+func waitForCodecServer(ctx context.Context, serverURL string, timeout time.Duration) error {
+	base, err := url.Parse(serverURL)
+	if err != nil {
+		return fmt.Errorf("invalid codec server URL: %v", err)
+	}
+	health := base.ResolveReference(&url.URL{Path: "healthz"}).String()
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	var lastErr error
+	err = wait.PollImmediateUntil(codecPollInterval, func() (bool, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, health, nil)
+		if err != nil {
+			return false, err
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			lastErr = err
+			return false, nil
+		}
+		res.Body.Close()
+		if res.StatusCode < 200 || res.StatusCode > 299 {
+			lastErr = fmt.Errorf("health check returned %s", res.Status)
+			return false, nil
+		}
+		return true, nil
+	}, ctx.Done())
+	if err == wait.ErrWaitTimeout {
+		return fmt.Errorf("codec server at %s not healthy after %v: %v", health, timeout, lastErr)
+	}
+	return err
+}
+
 func downloadCodecs(path, url string) error {
 	err := os.MkdirAll(path, 0777)
 	if err != nil {
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_waitForCodecServer(t *testing.T) {
+	defer func(i time.Duration) { codecPollInterval = i }(codecPollInterval)
+	codecPollInterval = time.Millisecond
+
+	tests := []struct {
+		name    string
+		failing int // number of failed health checks before the server is healthy
+		timeout time.Duration
+		wantErr bool
+	}{
+		{"healthy", 0, time.Second, false},
+		{"becomes healthy", 3, time.Second, false},
+		{"not healthy in time", 1 << 30, 20 * time.Millisecond, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checks := 0
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/healthz" {
+					t.Errorf("health check requested %s, want /healthz", r.URL.Path)
+				}
+				checks++
+				if checks <= tt.failing {
+					w.WriteHeader(http.StatusServiceUnavailable)
+				}
+			}))
+			defer ts.Close()
+
+			err := waitForCodecServer(context.Background(), ts.URL+"/", tt.timeout)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("waitForCodecServer() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && checks != tt.failing+1 {
+				t.Errorf("waitForCodecServer() made %d health checks, want %d", checks, tt.failing+1)
+			}
+		})
+	}
+
+	t.Run("unreachable", func(t *testing.T) {
+		ts := httptest.NewServer(http.NotFoundHandler())
+		ts.Close()
+		if err := waitForCodecServer(context.Background(), ts.URL+"/", 20*time.Millisecond); err == nil {
+			t.Errorf("waitForCodecServer() returned success for a closed server")
+		}
+	})
+}
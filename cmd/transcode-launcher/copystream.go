@@ -5,13 +5,15 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"time"
 
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/klog/v2"
 )
 
 // Convenience wrapper for listening on a given port and launcing dialAndCopy() for every
 // incoming connection
-func copyListener(ctx context.Context, listenAddr, serverAddr string) error {
+func copyListener(ctx context.Context, listenAddr, serverAddr string, b wait.Backoff) error {
 	l, err := net.Listen("tcp", listenAddr)
 	if err != nil {
 		return fmt.Errorf("failed to listen on %s: %v", listenAddr, err)
@@ -23,18 +25,17 @@ func copyListener(ctx context.Context, listenAddr, serverAddr string) error {
 		if err != nil {
 			return fmt.Errorf("Accept() returned an error: %v", err)
 		}
-		go dialAndCopy(ctx, cConn, serverAddr)
+		go dialAndCopy(ctx, cConn, serverAddr, b)
 	}
 }
 
 // dialAndCopy is a naive tunnel between 2 connections. It copies input and output between
 // The client and server. Any errors will close the connection
-func dialAndCopy(ctx context.Context, cConn net.Conn, addr string) {
+func dialAndCopy(ctx context.Context, cConn net.Conn, addr string, b wait.Backoff) {
 	// Close client connection once we are done
 	defer cConn.Close()
 
-	var d net.Dialer
-	sConn, err := d.DialContext(ctx, "tcp", addr)
+	sConn, err := dialWithBackoff(ctx, addr, b)
 	if err != nil {
 		klog.Exitf("Dial() failed: %v", err)
 	}
@@ -65,3 +66,22 @@ func dialAndCopy(ctx context.Context, cConn net.Conn, addr string) {
 		klog.Infof("context done")
 	}
 }
+
+// dialWithBackoff dials addr, retrying failed dials b.Steps times with
+// exponential backoff, e.g. while PMS is restarting
+func dialWithBackoff(ctx context.Context, addr string, b wait.Backoff) (net.Conn, error) {
+	var d net.Dialer
+	for {
+		conn, err := d.DialContext(ctx, "tcp", addr)
+		if err == nil || b.Steps < 1 {
+			return conn, err
+		}
+		delay := b.Step()
+		klog.Infof("Dial() failed: %v, retrying in %v", err, delay)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
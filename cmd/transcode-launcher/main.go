@@ -6,9 +6,11 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"time"
 
 	"github.com/munnerz/kube-plex/internal/ffmpeg"
 	"github.com/munnerz/kube-plex/internal/logger"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/klog/v2"
 )
 
@@ -17,11 +19,23 @@ var (
 	pmsAddr     = flag.String("pms-addr", "", "Address for the Plex Media Server instance (for example: '10.1.2.3:32400')")
 	codecServer = flag.String("codec-server-url", os.Getenv("CODEC_SERVER"), "URL for codec server (kube-plex)")
 	codecDir    = flag.String("codec-dir", os.Getenv("FFMPEG_EXTERNAL_LIBS"), "Directory to write codecs to, path will be created if doesn't exist")
+	codecWait   = flag.Duration("codec-server-wait", 0, "Time to wait for the codec server to become healthy before downloading codecs, no wait if zero")
 	logLevel    = flag.String("loglevel", "", "Set the loglevel for transcoding process")
+	complFile   = flag.String("completion-file", "", "Sentinel file to write the exit code to once the launcher is done, used to signal sidecars")
+	pmsRetries  = flag.Int("pms-retries", 0, "Number of times to retry failed connections to PMS")
+	pmsBackoff  = flag.Duration("pms-backoff", time.Second, "Delay before the first retry of a failed PMS connection, doubled for each retry")
+	pmsJitter   = flag.Float64("pms-jitter", 0, "Random fraction of the retry delay added to each delay")
+	maxMemory   = flag.Int64("max-memory", 0, "Address space limit of the transcode process in bytes, unlimited if zero")
+	maxCPUTime  = flag.Duration("max-cpu-time", 0, "CPU time limit of the transcode process, the process is killed once used up, unlimited if zero")
 )
 
 func main() {
 	rcode := launch()
+	if *complFile != "" {
+		if err := os.WriteFile(*complFile, []byte(fmt.Sprintf("%d\n", rcode)), 0644); err != nil {
+			klog.ErrorS(err, "failed to write completion file", "path", *complFile)
+		}
+	}
 	os.Exit(rcode)
 }
 
@@ -41,6 +55,12 @@ func launch() int {
 
 	if *codecServer != "" && *codecDir != "" {
 		klog.Infof("Codec server: %s", *codecServer)
+		if *codecWait > 0 {
+			if err := waitForCodecServer(ctx, *codecServer, *codecWait); err != nil {
+				klog.ErrorS(err, "codec server not ready")
+				return 1
+			}
+		}
 		err := downloadCodecs(*codecDir, *codecServer)
 		if err != nil {
 			klog.ErrorS(err, "failed to download codecs")
@@ -61,31 +81,45 @@ func launch() int {
 
 	klog.Infof("Creating tunnel server on port %s to %s", *listenAddr, *pmsAddr)
 	srvErr := make(chan error)
-	go func() { srvErr <- copyListener(ctx, *listenAddr, *pmsAddr) }()
+	b := wait.Backoff{Duration: *pmsBackoff, Factor: 2, Jitter: *pmsJitter, Steps: *pmsRetries}
+	go func() { srvErr <- copyListener(ctx, *listenAddr, *pmsAddr, b) }()
 
 	a := flag.Args()
 
 	cpath := a[0]
 	cargs := []string{}
+	targs := a[1:]
 	if *logLevel != "" {
+		// replaces the levels requested by Plex, the last one would win
 		klog.Infof("Setting debug level to %s on transcode process", *logLevel)
 		cargs = append(cargs,
 			"-loglevel", *logLevel,
 			"-loglevel_plex", *logLevel,
 		)
+		targs = ffmpeg.WithoutLogLevel(targs)
 	}
-	cargs = append(cargs, a[1:]...)
+	cargs = append(cargs, targs...)
 
 	klog.Infof("Transcode requested with command %v, args = %v", a[0], cargs)
 	cmd := exec.Command(cpath, cargs...)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 
+	klog.Info("Transcode begins...")
+	if err := cmd.Start(); err != nil {
+		klog.ErrorS(err, "transcode failed")
+		return 1
+	}
+	// Limits are applied right after the start, memory the transcoder maps
+	// before that counts towards the limit but isn't refused
+	if err := limitProcess(cmd.Process.Pid, *maxMemory, *maxCPUTime); err != nil {
+		klog.ErrorS(err, "failed to limit transcode process")
+		cmd.Process.Kill()
+		cmd.Wait()
+		return 1
+	}
 	cmdErr := make(chan error)
-	go func() {
-		klog.Info("Transcode begins...")
-		cmdErr <- cmd.Run()
-	}()
+	go func() { cmdErr <- cmd.Wait() }()
 
 	select {
 	case err := <-srvErr:
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// limitProcess sets the address space and CPU time limits of the process,
+// zero values leave the limit unchanged. Both the soft and the hard limit are
+// set so that the process can't raise them again.
+func limitProcess(pid int, mem int64, cpu time.Duration) error {
+	if mem > 0 {
+		if err := prlimit(pid, syscall.RLIMIT_AS, uint64(mem)); err != nil {
+			return fmt.Errorf("unable to limit address space to %d bytes: %w", mem, err)
+		}
+	}
+	if cpu > 0 {
+		// RLIMIT_CPU is in seconds, round up to not kill the process early
+		s := uint64((cpu + time.Second - 1) / time.Second)
+		if err := prlimit(pid, syscall.RLIMIT_CPU, s); err != nil {
+			return fmt.Errorf("unable to limit CPU time to %ds: %w", s, err)
+		}
+	}
+	return nil
+}
+
+// prlimit sets a resource limit of another process, syscall.Setrlimit only
+// applies to the calling process
+func prlimit(pid, resource int, v uint64) error {
+	l := syscall.Rlimit{Cur: v, Max: v}
+	_, _, errno := syscall.RawSyscall6(syscall.SYS_PRLIMIT64, uintptr(pid), uintptr(resource), uintptr(unsafe.Pointer(&l)), 0, 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func Test_limitProcess(t *testing.T) {
+	cmd := exec.Command("sleep", "10")
+	if err := cmd.Start(); err != nil {
+		t.Skipf("unable to start process: %v", err)
+	}
+	defer cmd.Wait()
+	defer cmd.Process.Kill()
+
+	if err := limitProcess(cmd.Process.Pid, 1<<30, 1500*time.Millisecond); err != nil {
+		t.Fatalf("limitProcess() error = %v", err)
+	}
+	l, err := os.ReadFile(fmt.Sprintf("/proc/%d/limits", cmd.Process.Pid))
+	if err != nil {
+		t.Fatalf("unable to read limits: %v", err)
+	}
+	for _, re := range []string{`Max cpu time\s+2\s+2\s+seconds`, `Max address space\s+1073741824\s+1073741824\s+bytes`} {
+		if !regexp.MustCompile(re).Match(l) {
+			t.Errorf("limits don't match `%s`:\n%s", re, l)
+		}
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// limitProcess is only supported on Linux, where transcode pods run
+func limitProcess(pid int, mem int64, cpu time.Duration) error {
+	if mem > 0 || cpu > 0 {
+		return errors.New("process limits are only supported on linux")
+	}
+	return nil
+}
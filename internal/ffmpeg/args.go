@@ -0,0 +1,162 @@
+package ffmpeg
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Info describes a transcode as far as it can be determined from the
+// transcoder command line
+type Info struct {
+	Codecs []string // decoders selected for input streams
+	Height int      // largest output height requested by scale filters
+}
+
+// audioCodecs are decoders that only produce audio streams
+var audioCodecs = map[string]bool{
+	"aac": true, "ac3": true, "alac": true, "ape": true, "dca": true,
+	"eac3": true, "flac": true, "mlp": true, "mp2": true, "mp3": true,
+	"opus": true, "truehd": true, "vorbis": true, "wavpack": true,
+	"wmav1": true, "wmav2": true, "wmapro": true,
+}
+
+// imageCodecs are decoders for still images, such as album art attached to
+// music files
+var imageCodecs = map[string]bool{"bmp": true, "gif": true, "mjpeg": true, "png": true}
+
+var (
+	codecArg  = regexp.MustCompile(`^-(c|codec)(:\d+)?$`)
+	filterArg = regexp.MustCompile(`^-(vf|filter(_complex)?)(:\d+)?$`)
+	scaleArg  = regexp.MustCompile(`scale=(?:w=)?(\d+):(?:h=)?(\d+)`)
+	levelArg  = regexp.MustCompile(`^-loglevel(_plex)?$`)
+	encArg    = regexp.MustCompile(`^-(?:(?:c|codec)(?::([a-z]))?(?::[\w:]+)?|([vas])codec)$`)
+)
+
+// nonVideoEncoders are audio and subtitle encoders, in addition to the
+// decoders in audioCodecs
+var nonVideoEncoders = map[string]bool{
+	"libmp3lame": true, "libopus": true, "libvorbis": true, "libfdk_aac": true,
+	"ass": true, "dvbsub": true, "dvdsub": true, "mov_text": true, "srt": true,
+	"ssa": true, "subrip": true, "webvtt": true,
+}
+
+// encoderCodecs map encoder names to the codec they produce, hardware
+// encoders such as hevc_nvenc are mapped by their prefix
+var encoderCodecs = map[string]string{
+	"libx264": "h264", "libx265": "hevc", "libvpx": "vp8", "libvpx-vp9": "vp9",
+	"libaom-av1": "av1", "libsvtav1": "av1", "librav1e": "av1", "libxvid": "mpeg4",
+}
+
+// ParseArgs inspects transcoder arguments. Codecs given before the first input
+// (`-i`) are decoders for the input streams, scale filters define the output
+// resolution.
+func ParseArgs(args []string) Info {
+	var i Info
+	input := true
+	for n := 0; n < len(args); n++ {
+		a := args[n]
+		switch {
+		case a == "-i":
+			input = false
+		case input && codecArg.MatchString(a) && n+1 < len(args):
+			n++
+			i.Codecs = append(i.Codecs, args[n])
+		case filterArg.MatchString(a) && n+1 < len(args):
+			n++
+			for _, m := range scaleArg.FindAllStringSubmatch(args[n], -1) {
+				if h, err := strconv.Atoi(m[2]); err == nil && h > i.Height {
+					i.Height = h
+				}
+			}
+		}
+	}
+	return i
+}
+
+// VideoCodec returns the decoder of the first input video stream, or an empty
+// string when there are no video streams
+func (i Info) VideoCodec() string {
+	for _, c := range i.Codecs {
+		if !audioCodecs[c] && !imageCodecs[c] && !strings.HasPrefix(c, "pcm_") {
+			return c
+		}
+	}
+	return ""
+}
+
+// AudioOnly reports whether all of the input streams are audio (or still
+// images)
+func (i Info) AudioOnly() bool {
+	return len(i.Codecs) > 0 && i.VideoCodec() == ""
+}
+
+// LogLevel returns the log level requested with `-loglevel_plex`, or with
+// `-loglevel` if the former isn't set. Flags such as `repeat+` are removed.
+func LogLevel(args []string) string {
+	var level, plex string
+	for n := 0; n+1 < len(args); n++ {
+		if m := levelArg.FindStringSubmatch(args[n]); m != nil {
+			n++
+			l := args[n][strings.LastIndex(args[n], "+")+1:]
+			if m[1] != "" {
+				plex = l
+			} else {
+				level = l
+			}
+		}
+	}
+	if plex != "" {
+		return plex
+	}
+	return level
+}
+
+// WithoutLogLevel returns args with the `-loglevel` and `-loglevel_plex`
+// options removed
+func WithoutLogLevel(args []string) []string {
+	out := make([]string, 0, len(args))
+	for n := 0; n < len(args); n++ {
+		if levelArg.MatchString(args[n]) && n+1 < len(args) {
+			n++
+			continue
+		}
+		out = append(out, args[n])
+	}
+	return out
+}
+
+// VideoEncoderCodec returns the codec of the first encoded output video stream,
+// e.g. hevc for libx265 or hevc_nvenc. Encoders given after the first input
+// (`-i`) are considered, in any order and with or without stream specifiers.
+// An empty string is returned when there are no video encoders, or the video
+// is copied.
+func VideoEncoderCodec(args []string) string {
+	input := true
+	for n := 0; n < len(args); n++ {
+		if args[n] == "-i" {
+			input = false
+			continue
+		}
+		m := encArg.FindStringSubmatch(args[n])
+		if input || m == nil || n+1 >= len(args) {
+			continue
+		}
+		n++
+		enc, stream := args[n], m[1]+m[2]
+		if stream != "" && stream != "v" || nonVideoEncoders[enc] || audioCodecs[enc] || imageCodecs[enc] || strings.HasPrefix(enc, "pcm_") {
+			continue
+		}
+		if enc == "copy" {
+			return ""
+		}
+		if c, ok := encoderCodecs[enc]; ok {
+			return c
+		}
+		if i := strings.Index(enc, "_"); i > 0 {
+			return enc[:i]
+		}
+		return enc
+	}
+	return ""
+}
@@ -0,0 +1,93 @@
+package ffmpeg
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseArgs(t *testing.T) {
+	tests := []struct {
+		name      string
+		args      string
+		want      Info
+		video     string
+		audioOnly bool
+	}{
+		{"no arguments", "", Info{}, "", false},
+		{"video transcode",
+			"-codec:0 hevc -codec:1 eac3 -ss 0 -i /data/movie.mkv -filter_complex [0:0]scale=w=1920:h=800[0];[0]format=pix_fmts=yuv420p|nv12[1] -map [1] -codec:0 libx264 -codec:1 aac",
+			Info{Codecs: []string{"hevc", "eac3"}, Height: 800}, "hevc", false},
+		{"4k output", "-c:0 h264 -i /data/movie.mkv -vf scale=3840:2160", Info{Codecs: []string{"h264"}, Height: 2160}, "h264", false},
+		{"music with album art", "-codec:0 flac -codec:1 mjpeg -i /data/song.flac -codec:0 libmp3lame", Info{Codecs: []string{"flac", "mjpeg"}}, "", true},
+		{"pcm audio", "-codec:0 pcm_s16le -i /data/song.wav", Info{Codecs: []string{"pcm_s16le"}}, "", true},
+		{"trailing codec flag", "-codec:0", Info{}, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ParseArgs(strings.Fields(tt.args))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseArgs() = %v, want %v", got, tt.want)
+			}
+			if v := got.VideoCodec(); v != tt.video {
+				t.Errorf("VideoCodec() = %v, want %v", v, tt.video)
+			}
+			if a := got.AudioOnly(); a != tt.audioOnly {
+				t.Errorf("AudioOnly() = %v, want %v", a, tt.audioOnly)
+			}
+		})
+	}
+}
+
+func TestLogLevel(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    string
+		want    string
+		without string
+	}{
+		{"no level", "-i /data/movie.mkv", "", "-i /data/movie.mkv"},
+		{"loglevel", "-loglevel quiet -i /data/movie.mkv", "quiet", "-i /data/movie.mkv"},
+		{"plex loglevel preferred", "-loglevel_plex verbose -loglevel quiet -i /data/movie.mkv", "verbose", "-i /data/movie.mkv"},
+		{"flags", "-loglevel repeat+level+debug -i /data/movie.mkv", "debug", "-i /data/movie.mkv"},
+		{"trailing flag", "-i /data/movie.mkv -loglevel", "", "-i /data/movie.mkv -loglevel"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := strings.Fields(tt.args)
+			if got := LogLevel(args); got != tt.want {
+				t.Errorf("LogLevel() = %v, want %v", got, tt.want)
+			}
+			if got := WithoutLogLevel(args); !reflect.DeepEqual(got, strings.Fields(tt.without)) {
+				t.Errorf("WithoutLogLevel() = %v, want %v", got, tt.without)
+			}
+		})
+	}
+}
+
+func TestVideoEncoderCodec(t *testing.T) {
+	tests := []struct {
+		name string
+		args string
+		want string
+	}{
+		{"no arguments", "", ""},
+		{"x264", "-codec:0 hevc -codec:1 eac3 -i /data/movie.mkv -map 0:0 -codec:0 libx264 -codec:1 aac", "h264"},
+		{"audio first", "-codec:0 h264 -i /data/movie.mkv -codec:1 aac -codec:0 libx265 -map 0:0", "hevc"},
+		{"stream type specifiers", "-i /data/movie.mkv -c:a libopus -c:v:0 libvpx-vp9", "vp9"},
+		{"legacy flags", "-i /data/movie.mkv -acodec libmp3lame -vcodec mpeg4", "mpeg4"},
+		{"hardware encoder", "-i /data/movie.mkv -codec:0 hevc_nvenc", "hevc"},
+		{"decoders only", "-codec:0 hevc -i /data/movie.mkv", ""},
+		{"copied video", "-i /data/movie.mkv -codec:0 copy -codec:1 aac", ""},
+		{"music", "-codec:0 flac -codec:1 mjpeg -i /data/song.flac -codec:0 libmp3lame", ""},
+		{"subtitles", "-i /data/movie.mkv -codec:2 mov_text -codec:0 libx264", "h264"},
+		{"trailing codec flag", "-i /data/movie.mkv -codec:0", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := VideoEncoderCodec(strings.Fields(tt.args)); got != tt.want {
+				t.Errorf("VideoEncoderCodec() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}